@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/KonBal/url-shortener/internal/app/session"
 	"github.com/KonBal/url-shortener/internal/app/user"
@@ -13,17 +14,52 @@ type authenticator interface {
 	Sign(token string) (string, error)
 }
 
+// Attributes of the auth cookie. The cookie is always HttpOnly.
+type CookieOptions struct {
+	Secure   bool
+	SameSite http.SameSite
+	Path     string
+	Domain   string
+	// Lifetime of the cookie, it should match lifetime of the auth token.
+	MaxAge time.Duration
+}
+
+// cookie returns the auth cookie with value, or a cookie removing it if value is empty.
+func (o CookieOptions) cookie(value string) *http.Cookie {
+	c := &http.Cookie{
+		Name:     authCookieKey,
+		Value:    value,
+		Path:     o.Path,
+		Domain:   o.Domain,
+		Secure:   o.Secure,
+		HttpOnly: true,
+		SameSite: o.SameSite,
+	}
+
+	if value == "" {
+		c.MaxAge = -1
+		c.Expires = time.Unix(0, 0)
+	} else if o.MaxAge > 0 {
+		c.MaxAge = int(o.MaxAge.Seconds())
+		c.Expires = time.Now().Add(o.MaxAge)
+	}
+
+	return c
+}
+
 type authHandler struct {
 	next          http.Handler
 	authenticator authenticator
+	cookie        CookieOptions
 }
 
 // AuthHandler create AuthHandler.
-func AuthHandler(a authenticator) func(http.Handler) http.Handler {
+func AuthHandler(a authenticator, c CookieOptions) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return &authHandler{
 			next:          h,
 			authenticator: a,
+			cookie:        c,
 		}
 	}
 }
@@ -52,6 +88,13 @@ func (h *authHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if u.Stale {
+		if err := setAuthCookie(w, h.authenticator, h.cookie, u.UserID); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	s := &session.Session{UserID: u.UserID}
 
 	ctx := session.ContextWithSession(req.Context(), s)
@@ -60,6 +103,17 @@ func (h *authHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	h.next.ServeHTTP(w, req)
 }
 
+// setAuthCookie signs user id with the newest key and sets it as the auth cookie.
+func setAuthCookie(w http.ResponseWriter, a authenticator, c CookieOptions, userID string) error {
+	signed, err := a.Sign(userID)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, c.cookie(signed))
+	return nil
+}
+
 type userStore interface {
 	NewAnonymousUser() *user.User
 }
@@ -67,15 +121,17 @@ type authenticationHandler struct {
 	next          http.Handler
 	authenticator authenticator
 	userStore     userStore
+	cookie        CookieOptions
 }
 
 // AuthenticationHandler creates handler that adds authentication to pipeline.
-func AuthenticationHandler(a authenticator, s userStore) func(http.Handler) http.Handler {
+func AuthenticationHandler(a authenticator, s userStore, c CookieOptions) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return &authenticationHandler{
 			next:          h,
 			authenticator: a,
 			userStore:     s,
+			cookie:        c,
 		}
 	}
 }
@@ -108,20 +164,33 @@ func (h *authenticationHandler) ServeHTTP(w http.ResponseWriter, req *http.Reque
 		}
 	}
 
-	if !auth {
-		signed, err := h.authenticator.Sign(u.UserID)
-		if err != nil {
+	// New users get a cookie, and stale cookies are signed again.
+	if !auth || u.Stale {
+		if err := setAuthCookie(w, h.authenticator, h.cookie, u.UserID); err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-
-		http.SetCookie(w, &http.Cookie{Name: authCookieKey, Value: signed})
 	}
 
-	s := &session.Session{UserID: u.UserID}
+	s := &session.Session{UserID: u.UserID, New: !auth}
 
 	ctx := session.ContextWithSession(req.Context(), s)
 	req = req.WithContext(ctx)
 
 	h.next.ServeHTTP(w, req)
 }
+
+type logoutHandler struct {
+	cookie CookieOptions
+}
+
+// LogoutHandler creates handler that removes the auth cookie.
+func LogoutHandler(c CookieOptions) http.Handler {
+	return &logoutHandler{cookie: c}
+}
+
+// ServeHTTP removes the auth cookie. Tokens are not revoked, a copy of the cookie works until it expires.
+func (h *logoutHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	http.SetCookie(w, h.cookie.cookie(""))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/config"
+	"github.com/KonBal/url-shortener/internal/app/session"
+	"github.com/KonBal/url-shortener/internal/app/user"
+	"github.com/stretchr/testify/require"
+)
+
+type constRand struct{}
+
+func (constRand) Next() uint64 { return 42 }
+
+func TestAuthenticationHandlerCookie(t *testing.T) {
+	keys := user.NewKeyStore(func() []byte { return []byte("key") })
+	cookie := CookieOptions{SameSite: http.SameSiteLaxMode, Path: "/", MaxAge: time.Hour}
+
+	var userID string
+	var newUser bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s := session.FromContext(req.Context())
+		userID, newUser = s.UserID, s.New
+	})
+
+	fresh := user.Authenticator{SecretKeyStore: keys, TTL: time.Hour}
+	token, err := fresh.Sign("user1234")
+	require.NoError(t, err)
+
+	// Expiry is kept in seconds, so the token expires right away.
+	expired, err := user.Authenticator{SecretKeyStore: keys, TTL: time.Nanosecond}.Sign("user1234")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		auth  user.Authenticator
+		token string
+
+		wantUserID  string
+		wantNewUser bool
+		wantRenewed bool
+	}{
+		"new_user":     {auth: fresh, wantNewUser: true, wantRenewed: true},
+		"valid_cookie": {auth: fresh, token: token, wantUserID: "user1234"},
+		"expired_cookie_with_legacy": {
+			auth:        user.Authenticator{SecretKeyStore: keys, TTL: time.Hour, AcceptLegacy: true},
+			token:       expired,
+			wantNewUser: true,
+			wantRenewed: true,
+		},
+		"near_expiry": {
+			auth:        user.Authenticator{SecretKeyStore: keys, TTL: time.Hour, RenewBefore: 2 * time.Hour},
+			token:       token,
+			wantUserID:  "user1234",
+			wantRenewed: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := AuthenticationHandler(tt.auth, user.NewStore(constRand{}), cookie)(next)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				req.AddCookie(&http.Cookie{Name: authCookieKey, Value: tt.token})
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			if tt.wantUserID != "" {
+				require.Equal(t, tt.wantUserID, userID)
+			}
+			if tt.wantNewUser {
+				require.NotEqual(t, "user1234", userID)
+			}
+			require.Equal(t, tt.wantNewUser, newUser)
+
+			cookies := res.Cookies()
+			if !tt.wantRenewed {
+				require.Empty(t, cookies)
+				return
+			}
+
+			require.Len(t, cookies, 1)
+			c := cookies[0]
+			require.Equal(t, authCookieKey, c.Name)
+			require.NotEqual(t, tt.token, c.Value)
+			require.True(t, c.HttpOnly)
+			require.Equal(t, http.SameSiteLaxMode, c.SameSite)
+			require.Equal(t, "/", c.Path)
+			require.Equal(t, 3600, c.MaxAge)
+
+			u, err := tt.auth.Authenticate(c.Value)
+			require.NoError(t, err)
+			require.Equal(t, userID, u.UserID)
+		})
+	}
+}
+
+func TestLogoutHandler(t *testing.T) {
+	h := LogoutHandler(CookieOptions{Secure: true, SameSite: http.SameSiteStrictMode, Path: "/", MaxAge: time.Hour})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/user/logout", nil))
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	cookies := res.Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, authCookieKey, cookies[0].Name)
+	require.Empty(t, cookies[0].Value)
+	require.Negative(t, cookies[0].MaxAge)
+	require.True(t, cookies[0].Secure)
+}
+
+func TestNewKeyStore(t *testing.T) {
+	tests := map[string]struct {
+		opt     config.Options
+		wantErr bool
+	}{
+		"secret_key":   {opt: config.Options{SecretKey: "key"}},
+		"no_key":       {opt: config.Options{}, wantErr: true},
+		"key_and_file": {opt: config.Options{SecretKey: "key", SecretKeyFile: "keys.txt"}, wantErr: true},
+		"missing_file": {opt: config.Options{SecretKeyFile: filepath.Join(t.TempDir(), "keys.txt")}, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			keys, err := newKeyStore(tt.opt)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, []byte(tt.opt.SecretKey), keys.Secret())
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/KonBal/url-shortener/internal/app/idempotency"
+	"github.com/KonBal/url-shortener/internal/app/session"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	// Bodies of requests with keys are kept in memory to be fingerprinted.
+	maxIdempotentBodySize = 1 << 20
+	// Seconds a client is asked to wait before retrying a request that is still in progress.
+	idempotencyRetryAfter = "1"
+)
+
+type idempotencyStore interface {
+	Begin(userID, key string, f idempotency.Fingerprint) (*idempotency.Response, error)
+	Finish(userID, key string, resp idempotency.Response)
+	Abort(userID, key string)
+}
+
+type idempotencyHandler struct {
+	next  http.Handler
+	store idempotencyStore
+}
+
+// IdempotencyHandler creates handler that replays saved responses of requests with Idempotency-Key header.
+// It must run after authentication, keys are scoped to users.
+// Requests with keys are rejected unless they carry a valid auth cookie: a client without one
+// gets a new user on every attempt, so its retries would never find the saved response.
+// The rejection sets the cookie of the new user, so the request can be repeated with it.
+func IdempotencyHandler(s idempotencyStore) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return &idempotencyHandler{
+			next:  h,
+			store: s,
+		}
+	}
+}
+
+// ServeHTTP adds idempotency keys to the pipeline.
+func (h *idempotencyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	key := req.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+
+	if len(key) > maxIdempotencyKeyLength {
+		http.Error(w, "idempotency key is too long", http.StatusBadRequest)
+		return
+	}
+
+	s := session.FromContext(req.Context())
+	if s.New {
+		http.Error(w, "idempotency key requires an auth cookie", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxIdempotentBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	userID := s.UserID
+	f := idempotency.NewFingerprint(req.Method, req.URL.Path, body)
+
+	saved, err := h.store.Begin(userID, key, f)
+	switch {
+	case errors.Is(err, idempotency.ErrKeyReused):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, idempotency.ErrInProgress):
+		w.Header().Set("Retry-After", idempotencyRetryAfter)
+		http.Error(w, "request with the same idempotency key is in progress, retry it later", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	case saved != nil:
+		for k, v := range saved.Header {
+			w.Header()[k] = v
+		}
+		w.Header().Set(idempotencyReplayedHeader, "true")
+		w.WriteHeader(saved.Status)
+		w.Write(saved.Body)
+		return
+	}
+
+	rw := &recordingWriter{ResponseWriter: w}
+	defer func() {
+		// Server errors are not saved, so that the request can be retried.
+		if rw.status == 0 || rw.status >= http.StatusInternalServerError {
+			h.store.Abort(userID, key)
+			return
+		}
+
+		h.store.Finish(userID, key, idempotency.Response{
+			Status: rw.status,
+			Header: rw.header,
+			Body:   rw.body.Bytes(),
+		})
+	}()
+
+	h.next.ServeHTTP(rw, req)
+}
+
+// recordingWriter keeps a copy of the response written through it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+// Write writes to response.
+func (r *recordingWriter) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// WriteHeader writes header to response.
+func (r *recordingWriter) WriteHeader(statusCode int) {
+	r.status = statusCode
+	r.header = r.ResponseWriter.Header().Clone()
+	r.ResponseWriter.WriteHeader(statusCode)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/idempotency"
+	"github.com/KonBal/url-shortener/internal/app/session"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyHandler(t *testing.T) {
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		body, _ := io.ReadAll(req.Body)
+		if string(body) == "fail" {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(strings.Repeat("x", calls)))
+	})
+	h := IdempotencyHandler(idempotency.NewStore(time.Hour, 0))(next)
+
+	do := func(userID string, newUser bool, key, body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		req = req.WithContext(session.ContextWithSession(req.Context(), &session.Session{UserID: userID, New: newUser}))
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	// Cases depend on each other, so they run in order.
+	tests := []struct {
+		name    string
+		userID  string
+		newUser bool
+		key     string
+		body    string
+
+		wantStatus   int
+		wantBody     string
+		wantReplayed bool
+		wantCalls    int
+	}{
+		{name: "first", userID: "1", key: "k", body: "a", wantStatus: http.StatusCreated, wantBody: "x", wantCalls: 1},
+		{name: "replay", userID: "1", key: "k", body: "a", wantStatus: http.StatusCreated, wantBody: "x", wantReplayed: true, wantCalls: 1},
+		{name: "different_body", userID: "1", key: "k", body: "b", wantStatus: http.StatusUnprocessableEntity, wantCalls: 1},
+		{name: "another_user", userID: "2", key: "k", body: "a", wantStatus: http.StatusCreated, wantBody: "xx", wantCalls: 2},
+		{name: "no_key", userID: "1", body: "a", wantStatus: http.StatusCreated, wantBody: "xxx", wantCalls: 3},
+		{name: "server_error", userID: "1", key: "f", body: "fail", wantStatus: http.StatusInternalServerError, wantCalls: 4},
+		{name: "error_retried", userID: "1", key: "f", body: "fail", wantStatus: http.StatusInternalServerError, wantCalls: 5},
+		{name: "new_user", userID: "3", newUser: true, key: "k", body: "a", wantStatus: http.StatusUnauthorized, wantCalls: 5},
+		{name: "new_user_no_key", userID: "3", newUser: true, body: "a", wantStatus: http.StatusCreated, wantBody: "xxxxxx", wantCalls: 6},
+		{name: "body_too_large", userID: "1", key: "l", body: strings.Repeat("a", maxIdempotentBodySize+1), wantStatus: http.StatusRequestEntityTooLarge, wantCalls: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := do(tt.userID, tt.newUser, tt.key, tt.body)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+			require.Equal(t, tt.wantCalls, calls)
+			require.Equal(t, tt.wantReplayed, resp.Header.Get(idempotencyReplayedHeader) == "true")
+
+			if tt.wantBody != "" {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.wantBody, string(body))
+				require.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// Store with every key in progress.
+type inProgressStore struct{}
+
+func (inProgressStore) Begin(string, string, idempotency.Fingerprint) (*idempotency.Response, error) {
+	return nil, idempotency.ErrInProgress
+}
+
+func (inProgressStore) Finish(string, string, idempotency.Response) {}
+
+func (inProgressStore) Abort(string, string) {}
+
+func TestIdempotencyHandlerInProgress(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Error("request in progress is processed again")
+	})
+	h := IdempotencyHandler(inProgressStore{})(next)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("a"))
+	req.Header.Set(idempotencyKeyHeader, "k")
+	req = req.WithContext(session.ContextWithSession(req.Context(), &session.Session{UserID: "1"}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.Equal(t, idempotencyRetryAfter, resp.Header.Get("Retry-After"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "in progress")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/KonBal/url-shortener/internal/app/config"
+	"github.com/KonBal/url-shortener/internal/app/idgen"
+	"github.com/KonBal/url-shortener/internal/app/logger"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewIDGenerator(t *testing.T) {
+	tests := map[string]struct {
+		opt     config.Options
+		wantErr bool
+	}{
+		"random_fixed_length":      {opt: config.Options{IDGenerator: "random", CodeLength: 6}},
+		"crypto_fixed_length":      {opt: config.Options{IDGenerator: "crypto", CodeLength: 6}},
+		"snowflake":                {opt: config.Options{IDGenerator: "snowflake", NodeID: 0}},
+		"snowflake_no_node":        {opt: config.Options{IDGenerator: "snowflake", NodeID: -1}, wantErr: true},
+		"snowflake_node_too_large": {opt: config.Options{IDGenerator: "snowflake", NodeID: idgen.MaxNode + 1}, wantErr: true},
+		"snowflake_fixed_length":   {opt: config.Options{IDGenerator: "snowflake", NodeID: 1, CodeLength: 6}, wantErr: true},
+		"block_fixed_length":       {opt: config.Options{IDGenerator: "block", IDBlockSize: 10, CodeLength: 6}, wantErr: true},
+		"block_without_db":         {opt: config.Options{IDGenerator: "block", IDBlockSize: 10}, wantErr: true},
+		"unknown":                  {opt: config.Options{IDGenerator: "sequence"}, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			gen, err := newIDGenerator(tt.opt, nil, idgen.New(), logger.NewLogger(zap.NewNop()))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, gen)
+		})
+	}
+}
@@ -1,21 +1,30 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 
 	"github.com/KonBal/url-shortener/internal/app/base62"
 	"github.com/KonBal/url-shortener/internal/app/config"
+	"github.com/KonBal/url-shortener/internal/app/idempotency"
 	"github.com/KonBal/url-shortener/internal/app/idgen"
 	"github.com/KonBal/url-shortener/internal/app/logger"
 	"github.com/KonBal/url-shortener/internal/app/operation"
 	"github.com/KonBal/url-shortener/internal/app/storage"
+	"github.com/KonBal/url-shortener/internal/app/urlpolicy"
 	"github.com/KonBal/url-shortener/internal/app/user"
 	"github.com/KonBal/url-shortener/migrations"
 	"github.com/go-chi/chi/v5"
@@ -23,7 +32,9 @@ import (
 )
 
 func main() {
-	config.Parse()
+	if err := config.Parse(); err != nil {
+		log.Fatalf("main: failed to parse config: %v", err)
+	}
 
 	baseLogger, err := zap.NewDevelopment()
 	if err != nil {
@@ -47,11 +58,22 @@ func run(log *logger.Logger) error {
 
 	randGen := idgen.New()
 
+	alphabet := opt.CodeAlphabet
+	if alphabet == "readable" {
+		alphabet = base62.ReadableAlphabet
+	}
+
+	encoder, err := base62.NewEncoder(alphabet, opt.CodeLength)
+	if err != nil {
+		return err
+	}
+
 	var s storage.Storage
+	var db *sql.DB
 
 	switch {
 	case opt.DBConnectionString != "":
-		db, err := sql.Open("pgx", opt.DBConnectionString)
+		db, err = sql.Open("pgx", opt.DBConnectionString)
 		if err != nil {
 			return fmt.Errorf("failed to establish connection: %w", err)
 		} else if err = db.Ping(); err != nil {
@@ -66,6 +88,14 @@ func run(log *logger.Logger) error {
 		}
 
 		s = dbs
+	case opt.BoltStoragePath != "":
+		bs, err := storage.NewBoltStorage(opt.BoltStoragePath)
+		if err != nil {
+			return err
+		}
+		defer bs.Close()
+
+		s = bs
 	case opt.FileStoragePath != "":
 		fs, err := storage.NewFileStorage(opt.FileStoragePath, randGen)
 		if err != nil {
@@ -78,44 +108,130 @@ func run(log *logger.Logger) error {
 		s = storage.NewInMemory()
 	}
 
-	userStore := user.NewStore(randGen)
+	if opt.CacheSize > 0 {
+		cs := storage.NewCachedStorage(s, opt.CacheSize, opt.CacheTTL)
+		expvar.Publish("storage_cache", expvar.Func(func() any { return cs.Stats() }))
+
+		s = cs
+	}
+
+	idGen, err := newIDGenerator(opt, db, randGen, log)
+	if err != nil {
+		return err
+	}
+
+	policy := urlpolicy.Chain{urlpolicy.NewSelfLoop(opt.BaseURL)}
+	if opt.PolicyFile != "" {
+		list, err := urlpolicy.NewFileList(opt.PolicyFile, opt.PolicyAllowlist, opt.PolicyReloadPeriod, log)
+		if err != nil {
+			return err
+		}
+		defer list.Close()
+
+		policy = append(policy, list)
+	}
+
+	keyStore, err := newKeyStore(opt)
+	if err != nil {
+		return err
+	}
+
+	// User ids must not be predictable from earlier ones, so they are not taken from math/rand.
+	userStore := user.NewStore(idgen.NewCrypto())
 	authenticator := user.Authenticator{
-		SecretKeyStore: user.NewKeyStore(func() []byte { return []byte("my_secret_key") }),
+		SecretKeyStore: keyStore,
+		TTL:            opt.AuthTokenTTL,
+		RenewBefore:    opt.AuthRenewBefore,
+		AcceptLegacy:   opt.AuthLegacyTokens,
+		LegacySecrets:  [][]byte{[]byte(legacySecretKey)},
+	}
+
+	cookie, err := newCookieOptions(opt)
+	if err != nil {
+		return err
 	}
 
 	logged := LoggingHandler(log)
 	compressed := ZipHandler()
-	authorised := AuthHandler(authenticator)
-	authenticated := AuthenticationHandler(authenticator, userStore)
+	authorised := AuthHandler(authenticator, cookie)
+	authenticated := AuthenticationHandler(authenticator, userStore, cookie)
+	idempotent := func(h http.Handler) http.Handler { return h }
+	if opt.IdempotencyTTL > 0 {
+		idempotent = IdempotencyHandler(idempotency.NewStore(opt.IdempotencyTTL, opt.IdempotencyMaxKeys))
+	}
 
 	shortURLService := operation.ShortURLService{
 		BaseURL:    opt.BaseURL,
-		Encoder:    base62.Encoder{},
+		Encoder:    encoder,
 		Storage:    s,
-		Uint64Rand: randGen,
+		Uint64Rand: idGen,
+		Normalizer: operation.URLNormalizer{
+			Schemes:       splitList(opt.AllowedSchemes),
+			SortQuery:     opt.SortQuery,
+			StripFragment: opt.StripFragment,
+		},
+		Policy: policy,
+	}
+	// Workers are shut down in reverse order after the server stops,
+	// so that their pending work is saved before storage is closed.
+	var workers []interface {
+		Shutdown(ctx context.Context) error
 	}
+
 	deletionWorker := operation.NewDeletionWorker(s, log, 1024, 10)
+	expvar.Publish("deletion_worker", expvar.Func(func() any { return deletionWorker.Stats() }))
+	workers = append(workers, deletionWorker)
+	if opt.DeletedRetention > 0 {
+		workers = append(workers, operation.NewPurgeWorker(s, log, opt.DeletedRetention, 1000, time.Hour))
+	}
+	if opt.IPHashSalt == "" {
+		log.Warnf("no ip hash salt is configured, hashes of client addresses change on restart")
+	}
+	clickRecorder, err := operation.NewClickRecorder(s, log, []byte(opt.IPHashSalt), 1024, 5)
+	if err != nil {
+		return err
+	}
+	workers = append(workers, clickRecorder)
 
 	router.Method(http.MethodPost, "/",
-		authenticated((compressed((&operation.Shorten{
+		authenticated(compressed(idempotent(&operation.Shorten{
 			Log:     log,
 			Service: shortURLService,
-		})))))
+		}))))
 
 	router.Method(http.MethodPost, "/api/shorten",
-		authenticated((compressed((&operation.ShortenFromJSON{
+		authenticated(compressed(idempotent(&operation.ShortenFromJSON{
 			Log:     log,
 			Service: shortURLService,
-		})))))
+		}))))
 
 	router.Method(http.MethodPost, "/api/shorten/batch",
-		authenticated((compressed((&operation.ShortenBatch{
+		authenticated(compressed(idempotent(&operation.ShortenBatch{
 			Log:     log,
 			Service: shortURLService,
-		})))))
+		}))))
 
 	router.Method(http.MethodGet, "/api/user/urls",
 		authorised(logged(compressed(&operation.GetUserURLs{
+			Log:     log,
+			BaseURL: opt.BaseURL,
+			Service: shortURLService,
+		}))))
+
+	router.Method(http.MethodGet, "/api/user/urls/{short}/stats",
+		authorised(logged(compressed(&operation.GetURLStats{
+			Log:     log,
+			Service: shortURLService,
+		}))))
+
+	router.Method(http.MethodPatch, "/api/user/urls/{short}",
+		authorised(logged(compressed(&operation.UpdateURL{
+			Log:     log,
+			Service: shortURLService,
+		}))))
+
+	router.Method(http.MethodPost, "/api/user/urls/restore",
+		authorised(logged(compressed(&operation.Restore{
 			Log:     log,
 			Service: shortURLService,
 		}))))
@@ -127,18 +243,158 @@ func run(log *logger.Logger) error {
 		})),
 	)
 
+	router.Method(http.MethodGet, "/api/user/deletions/{id}",
+		authorised(logged(compressed(&operation.GetDeletion{
+			Log:     log,
+			Service: deletionWorker,
+		}))))
+
+	router.Method(http.MethodPost, "/api/user/logout", logged(LogoutHandler(cookie)))
+
 	router.Method(http.MethodGet, "/{short}",
 		authenticated((compressed((&operation.Expand{
 			Log:     log,
 			Service: shortURLService,
+			Clicks:  clickRecorder,
 		})))))
 
 	router.Method(http.MethodGet, "/ping", logged(&operation.Ping{Log: log, Storage: s}))
 
+	router.Handle("/debug/vars", expvar.Handler())
 	router.HandleFunc("/debug/pprof/", pprof.Index)
 	router.HandleFunc("/debug/pprof/{action}", pprof.Index)
 	router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	router.HandleFunc("/debug/pprof/profile", pprof.Profile)
 
-	return http.ListenAndServe(opt.ServerAddress, router)
+	server := &http.Server{Addr: opt.ServerAddress, Handler: router}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Infof("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opt.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("server shutdown: %w", err))
+	}
+
+	for i := len(workers) - 1; i >= 0; i-- {
+		if err := workers[i].Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("worker shutdown: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// newIDGenerator returns generator of short url ids chosen in options.
+// Sequential generators are rejected with fixed length codes, since their ids
+// would be truncated to the lowest digits and repeat once the codes wrap around.
+func newIDGenerator(opt config.Options, db *sql.DB, randGen operation.Rand, log *logger.Logger) (operation.Rand, error) {
+	switch opt.IDGenerator {
+	case "", "random":
+		return randGen, nil
+	case "crypto":
+		return idgen.NewCrypto(), nil
+	case "snowflake":
+		if opt.CodeLength > 0 {
+			return nil, errors.New("snowflake id generator cannot be used with fixed code length")
+		}
+		if opt.NodeID < 0 {
+			return nil, errors.New("snowflake id generator requires node id")
+		}
+		return idgen.NewSnowflake(opt.NodeID)
+	case "block":
+		if opt.CodeLength > 0 {
+			return nil, errors.New("block id generator cannot be used with fixed code length")
+		}
+		if db == nil {
+			return nil, errors.New("block id generator requires db connection")
+		}
+		if opt.IDBlockSize <= 0 {
+			return nil, fmt.Errorf("invalid id block size %d", opt.IDBlockSize)
+		}
+		return idgen.NewBlockAllocator(db, uint64(opt.IDBlockSize), idgen.NewCrypto(), log)
+	default:
+		return nil, fmt.Errorf("unknown id generator %q", opt.IDGenerator)
+	}
+}
+
+// Key that signed auth cookies before keys became configurable.
+// Legacy cookies signed with it are accepted to be replaced with current ones.
+const legacySecretKey = "my_secret_key"
+
+// newKeyStore returns store of keys signing auth cookies chosen in options.
+// A key is required, so that cookies survive restarts.
+func newKeyStore(opt config.Options) (user.KeyStore, error) {
+	switch {
+	case opt.SecretKey != "" && opt.SecretKeyFile != "":
+		return user.KeyStore{}, errors.New("secret key and secret key file are mutually exclusive")
+	case opt.SecretKeyFile != "":
+		keys, err := user.ReadKeyFile(opt.SecretKeyFile)
+		if err != nil {
+			return user.KeyStore{}, err
+		}
+		return user.NewVersionedKeyStore(keys)
+	case opt.SecretKey != "":
+		return user.NewKeyStore(func() []byte { return []byte(opt.SecretKey) }), nil
+	}
+
+	return user.KeyStore{}, errors.New("secret key or secret key file is required")
+}
+
+// newCookieOptions returns attributes of the auth cookie chosen in options.
+func newCookieOptions(opt config.Options) (CookieOptions, error) {
+	c := CookieOptions{Path: opt.CookiePath, Domain: opt.CookieDomain, MaxAge: opt.AuthTokenTTL}
+
+	switch strings.ToLower(opt.CookieSecure) {
+	case "auto":
+		c.Secure = strings.HasPrefix(opt.BaseURL, "https://")
+	case "true":
+		c.Secure = true
+	case "false":
+	default:
+		return c, fmt.Errorf("invalid cookie secure %q", opt.CookieSecure)
+	}
+
+	switch strings.ToLower(opt.CookieSameSite) {
+	case "lax":
+		c.SameSite = http.SameSiteLaxMode
+	case "strict":
+		c.SameSite = http.SameSiteStrictMode
+	case "none":
+		if !c.Secure {
+			return c, errors.New("cookie samesite none requires secure cookie")
+		}
+		c.SameSite = http.SameSiteNoneMode
+	default:
+		return c, fmt.Errorf("invalid cookie samesite %q", opt.CookieSameSite)
+	}
+
+	return c, nil
+}
+
+// splitList splits comma separated list dropping empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -25,7 +25,7 @@ type shortener struct {
 	shortened string
 }
 
-func (s shortener) Shorten(ctx context.Context, url string, userID string) (string, error) {
+func (s shortener) Shorten(ctx context.Context, url string, userID string, opts operation.ShortenOptions) (string, error) {
 	return fmt.Sprintf("%s/%s", s.baseURL, s.shortened), nil
 }
 
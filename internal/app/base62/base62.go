@@ -1,24 +1,120 @@
 package base62
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 )
 
 // Encoder.
-type Encoder struct{}
+// The zero value encodes with Alphabet into codes of variable length.
+type Encoder struct {
+	// Set of symbols used in encoded values, Alphabet if empty.
+	Alphabet string
+	// Number of symbols in encoded values if positive.
+	// Values that do not fit are truncated to their lowest digits.
+	Length int
+}
+
+// Alphabet is the set of symbols used in encoded values.
+const Alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// ReadableAlphabet is Alphabet without look-alike symbols '0', 'O', 'o', 'I', 'l' and '1'.
+const ReadableAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Error when decoded value contains a symbol outside of the alphabet.
+var ErrInvalidSymbol = errors.New("invalid symbol")
+
+// Error when decoded value does not fit into uint64.
+var ErrOverflow = errors.New("value overflows uint64")
+
+// NewEncoder returns encoder with the given alphabet and length.
+// Empty alphabet means Alphabet, zero length means variable length.
+// The alphabet may contain only letters, digits, '-', '.' and '_', so that codes need no escaping in urls.
+func NewEncoder(alphabet string, length int) (Encoder, error) {
+	if length < 0 {
+		return Encoder{}, fmt.Errorf("base62: negative length %d", length)
+	}
+
+	if alphabet != "" {
+		if len(alphabet) < 2 {
+			return Encoder{}, fmt.Errorf("base62: alphabet %q is too short", alphabet)
+		}
 
-const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-const length = uint64(len(alphabet))
+		for i := 0; i < len(alphabet); i++ {
+			if !isURLSafe(alphabet[i]) {
+				return Encoder{}, fmt.Errorf("base62: alphabet %q contains symbol '%c' not allowed in urls", alphabet, alphabet[i])
+			}
+			if strings.IndexByte(alphabet[i+1:], alphabet[i]) >= 0 {
+				return Encoder{}, fmt.Errorf("base62: alphabet %q contains symbol '%c' twice", alphabet, alphabet[i])
+			}
+		}
+	}
+
+	return Encoder{Alphabet: alphabet, Length: length}, nil
+}
+
+// isURLSafe reports whether b is an unreserved url symbol other than '~'.
+func isURLSafe(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || b == '-' || b == '.' || b == '_'
+}
+
+func (c Encoder) alphabet() string {
+	if c.Alphabet == "" {
+		return Alphabet
+	}
+	return c.Alphabet
+}
 
 // Encode encodes uint64 value into a string.
-// It uses base62-alphabet which is base64-alphabet without symbols '+' and '/'.
+// By default it uses base62-alphabet which is base64-alphabet without symbols '+' and '/'.
+// Digits are written starting from the lowest one.
 func (c Encoder) Encode(val uint64) string {
+	alphabet := c.alphabet()
+	base := uint64(len(alphabet))
+
 	var b strings.Builder
+
+	if c.Length > 0 {
+		b.Grow(c.Length)
+
+		for i := 0; i < c.Length; i++ {
+			b.WriteByte(alphabet[val%base])
+			val = val / base
+		}
+
+		return b.String()
+	}
+
 	b.Grow(11)
 
-	for ; val > 0; val = val / length {
-		b.WriteByte(alphabet[(val % length)])
+	for ; val > 0; val = val / base {
+		b.WriteByte(alphabet[(val % base)])
 	}
 
 	return b.String()
 }
+
+// Decode decodes a string produced by Encode back into uint64 value.
+// Values truncated by fixed length encoding decode into their lowest digits.
+func (c Encoder) Decode(s string) (uint64, error) {
+	alphabet := c.alphabet()
+	base := uint64(len(alphabet))
+
+	var val uint64
+
+	for i := len(s) - 1; i >= 0; i-- {
+		d := strings.IndexByte(alphabet, s[i])
+		if d < 0 {
+			return 0, fmt.Errorf("base62: decode %q: %w '%c'", s, ErrInvalidSymbol, s[i])
+		}
+
+		if val > (^uint64(0)-uint64(d))/base {
+			return 0, fmt.Errorf("base62: decode %q: %w", s, ErrOverflow)
+		}
+
+		val = val*base + uint64(d)
+	}
+
+	return val, nil
+}
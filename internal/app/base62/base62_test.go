@@ -3,6 +3,8 @@ package base62
 import (
 	"math"
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestEncode(t *testing.T) {
@@ -41,6 +43,120 @@ func TestEncode(t *testing.T) {
 	}
 }
 
+func TestEncodeFixedLength(t *testing.T) {
+	tests := map[string]struct {
+		encoder Encoder
+		val     uint64
+		want    string
+	}{
+		"padded": {
+			encoder: Encoder{Length: 6},
+			val:     math.MaxUint8,
+			want:    "heaaaa",
+		},
+		"truncated": {
+			encoder: Encoder{Length: 6},
+			val:     math.MaxUint64,
+			want:    "pIrkgb",
+		},
+		"readable": {
+			encoder: Encoder{Alphabet: ReadableAlphabet, Length: 7},
+			val:     math.MaxUint64,
+			want:    "rCB6HB2",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.encoder.Encode(tt.val))
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := map[string]struct {
+		encoder Encoder
+		s       string
+
+		want    uint64
+		wantErr error
+	}{
+		"empty": {
+			s:    "",
+			want: 0,
+		},
+		"max_uint64": {
+			s:    "pIrkgbKrQ8v",
+			want: math.MaxUint64,
+		},
+		"padded": {
+			encoder: Encoder{Length: 6},
+			s:       "heaaaa",
+			want:    math.MaxUint8,
+		},
+		"invalid_symbol": {
+			encoder: Encoder{Alphabet: ReadableAlphabet},
+			s:       "abc0",
+			wantErr: ErrInvalidSymbol,
+		},
+		"overflow": {
+			s:       "pIrkgbKrQ8w",
+			wantErr: ErrOverflow,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tt.encoder.Decode(tt.s)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	for _, c := range []Encoder{{}, {Alphabet: ReadableAlphabet}, {Alphabet: "01"}} {
+		for _, v := range []uint64{0, 1, 61, 62, math.MaxUint32, math.MaxUint64} {
+			got, err := c.Decode(c.Encode(v))
+			require.NoError(t, err)
+			require.Equal(t, v, got)
+		}
+	}
+}
+
+func TestNewEncoder(t *testing.T) {
+	tests := map[string]struct {
+		alphabet string
+		length   int
+		wantErr  bool
+	}{
+		"default":         {},
+		"readable":        {alphabet: ReadableAlphabet, length: 7},
+		"negative_length": {length: -1, wantErr: true},
+		"too_short":       {alphabet: "a", wantErr: true},
+		"repeated_symbol": {alphabet: "abca", wantErr: true},
+		"non_ascii":       {alphabet: "abcй", wantErr: true},
+		"url_safe":        {alphabet: "ab-._"},
+		"tilde":           {alphabet: "abc~", wantErr: true},
+		"slash":           {alphabet: "abc/", wantErr: true},
+		"percent":         {alphabet: "abc%", wantErr: true},
+		"space":           {alphabet: "abc ", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewEncoder(tt.alphabet, tt.length)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
 func BenchmarkEncode(b *testing.B) {
 	c := Encoder{}
 
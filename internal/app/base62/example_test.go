@@ -11,3 +11,12 @@ func Example() {
 	fmt.Println(en.Encode(123))
 	fmt.Println(en.Encode(math.MaxUint64))
 }
+
+func ExampleEncoder_Decode() {
+	en := Encoder{Alphabet: ReadableAlphabet, Length: 7}
+
+	code := en.Encode(123456789)
+	val, _ := en.Decode(code)
+
+	fmt.Println(code, val)
+}
@@ -2,7 +2,10 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Configuration of the app.
@@ -10,17 +13,110 @@ type Options struct {
 	BaseURL            string `env:"BASE_URL"`
 	DBConnectionString string `env:"DATABASE_DSN"`
 	FileStoragePath    string `env:"FILE_STORAGE_PATH"`
+	BoltStoragePath    string `env:"BOLT_STORAGE_PATH"`
 	ServerAddress      string `env:"SERVER_ADDRESS"`
+
+	// Number of GetByShort results kept in cache, zero disables caching.
+	// The cache is local to an instance, writes through other instances are seen only after CacheTTL.
+	CacheSize int           `env:"CACHE_SIZE"`
+	CacheTTL  time.Duration `env:"CACHE_TTL"`
+
+	// Symbols of generated short urls, "readable" for the alphabet without look-alike symbols.
+	CodeAlphabet string `env:"CODE_ALPHABET"`
+	// Length of generated short urls, zero means variable length up to 11 symbols.
+	CodeLength int `env:"CODE_LENGTH"`
+
+	// Generator of short url ids: random, crypto, snowflake or block.
+	// Snowflake and block generators require variable length codes.
+	IDGenerator string `env:"ID_GENERATOR"`
+	// Id of the instance for the snowflake generator, negative if not set.
+	// It must be set explicitly, so that instances do not share the default one.
+	NodeID int `env:"NODE_ID"`
+	// Number of ids reserved at once by the block generator.
+	IDBlockSize int `env:"ID_BLOCK_SIZE"`
+
+	// Comma separated schemes of urls allowed for shortening.
+	AllowedSchemes string `env:"ALLOWED_SCHEMES"`
+	// Sort query parameters of urls before shortening.
+	SortQuery bool `env:"SORT_QUERY"`
+	// Drop fragments of urls before shortening.
+	StripFragment bool `env:"STRIP_FRAGMENT"`
+
+	// File with domains and patterns of urls rejected for shortening, or the only ones allowed in allowlist mode.
+	PolicyFile         string        `env:"URL_POLICY_FILE"`
+	PolicyAllowlist    bool          `env:"URL_POLICY_ALLOWLIST"`
+	PolicyReloadPeriod time.Duration `env:"URL_POLICY_RELOAD_PERIOD"`
+
+	// Salt of hashes of client addresses in click events, random for each run if empty.
+	IPHashSalt string `env:"IP_HASH_SALT"`
+
+	// Time deleted urls are kept before they are purged, zero disables purging.
+	// Purged codes can be taken again, so purging is off unless it is set.
+	DeletedRetention time.Duration `env:"DELETED_RETENTION"`
+
+	// Time responses of requests with Idempotency-Key header are kept, zero disables idempotency keys.
+	IdempotencyTTL time.Duration `env:"IDEMPOTENCY_TTL"`
+	// Number of idempotency keys kept, the oldest ones are dropped for new ones.
+	IdempotencyMaxKeys int `env:"IDEMPOTENCY_MAX_KEYS"`
+
+	// Secret key signing auth cookies, it or SecretKeyFile is required.
+	SecretKey string `env:"SECRET_KEY"`
+	// File with versioned keys signing auth cookies, see user.ReadKeyFile. Excludes SecretKey.
+	SecretKeyFile string `env:"SECRET_KEY_FILE"`
+	// Lifetime of auth tokens.
+	AuthTokenTTL time.Duration `env:"AUTH_TOKEN_TTL"`
+	// Accept auth tokens of the format without expiry, they are replaced with current tokens.
+	AuthLegacyTokens bool `env:"AUTH_LEGACY_TOKENS"`
+	// Auth tokens expiring sooner are renewed.
+	AuthRenewBefore time.Duration `env:"AUTH_RENEW_BEFORE"`
+
+	// Secure attribute of the auth cookie: true, false or auto to set it when base url is https.
+	CookieSecure string `env:"COOKIE_SECURE"`
+	// SameSite attribute of the auth cookie: lax, strict or none.
+	CookieSameSite string `env:"COOKIE_SAMESITE"`
+	CookiePath     string `env:"COOKIE_PATH"`
+	CookieDomain   string `env:"COOKIE_DOMAIN"`
+
+	// Time given to in-flight requests and background jobs to finish on shutdown.
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT"`
 }
 
 var opt Options
 
 // Parse parses the options from command line or environment variables.
-func Parse() {
+func Parse() error {
 	flag.StringVar(&opt.BaseURL, "b", "localhost:8080", "address of short url host")
 	flag.StringVar(&opt.DBConnectionString, "d", "", "db connection string")
 	flag.StringVar(&opt.FileStoragePath, "f", "/tmp/short-url-db.json", "name of file for storing short url")
 	flag.StringVar(&opt.ServerAddress, "a", "localhost:8080", "host address")
+	flag.StringVar(&opt.BoltStoragePath, "bolt-path", "", "name of embedded key-value database file for storing short url")
+	flag.IntVar(&opt.CacheSize, "cache-size", 0, "number of short urls kept in cache, 0 (default) disables caching; with several instances changes made by others are seen after cache-ttl")
+	flag.DurationVar(&opt.CacheTTL, "cache-ttl", time.Minute, "time short urls are kept in cache")
+	flag.StringVar(&opt.CodeAlphabet, "code-alphabet", "", `symbols of generated short urls, "readable" excludes look-alike symbols`)
+	flag.IntVar(&opt.CodeLength, "code-length", 0, "length of generated short urls, 0 means variable length")
+	flag.StringVar(&opt.IDGenerator, "id-gen", "random", "generator of short url ids: random, crypto, snowflake or block (requires db), the last two require variable code length")
+	flag.IntVar(&opt.NodeID, "node-id", -1, "id of the instance for snowflake generator, required by it")
+	flag.IntVar(&opt.IDBlockSize, "id-block-size", 1000, "number of ids reserved at once by block generator")
+	flag.StringVar(&opt.AllowedSchemes, "allowed-schemes", "http,https", "comma separated schemes of urls allowed for shortening")
+	flag.BoolVar(&opt.SortQuery, "sort-query", false, "sort query parameters of urls before shortening")
+	flag.BoolVar(&opt.StripFragment, "strip-fragment", false, "drop fragments of urls before shortening")
+	flag.StringVar(&opt.PolicyFile, "policy-file", "", "file with domains and patterns of urls rejected for shortening")
+	flag.BoolVar(&opt.PolicyAllowlist, "policy-allowlist", false, "allow only urls listed in policy file")
+	flag.StringVar(&opt.IPHashSalt, "ip-hash-salt", "", "salt of hashes of client addresses in click stats, random for each run if empty")
+	flag.DurationVar(&opt.DeletedRetention, "deleted-retention", 0, "time deleted urls are kept before they are purged and their codes freed, 0 (default) disables purging")
+	flag.DurationVar(&opt.IdempotencyTTL, "idempotency-ttl", 24*time.Hour, "time responses of requests with idempotency key are kept, 0 disables idempotency keys")
+	flag.IntVar(&opt.IdempotencyMaxKeys, "idempotency-max-keys", 100000, "number of idempotency keys kept, 0 means no limit")
+	flag.StringVar(&opt.SecretKey, "secret-key", "", "secret key signing auth cookies, required unless secret key file is given")
+	flag.StringVar(&opt.SecretKeyFile, "secret-key-file", "", "file with versioned keys signing auth cookies")
+	flag.DurationVar(&opt.AuthTokenTTL, "auth-token-ttl", 30*24*time.Hour, "lifetime of auth tokens")
+	flag.BoolVar(&opt.AuthLegacyTokens, "auth-legacy-tokens", true, "accept auth tokens of the format without expiry")
+	flag.DurationVar(&opt.AuthRenewBefore, "auth-renew-before", 7*24*time.Hour, "auth tokens expiring sooner are renewed")
+	flag.StringVar(&opt.CookieSecure, "cookie-secure", "auto", "secure attribute of auth cookie: true, false or auto to set it for https base url")
+	flag.StringVar(&opt.CookieSameSite, "cookie-samesite", "lax", "samesite attribute of auth cookie: lax, strict or none")
+	flag.StringVar(&opt.CookiePath, "cookie-path", "/", "path attribute of auth cookie")
+	flag.StringVar(&opt.CookieDomain, "cookie-domain", "", "domain attribute of auth cookie")
+	flag.DurationVar(&opt.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "time given to in-flight requests and background jobs to finish on shutdown")
+	flag.DurationVar(&opt.PolicyReloadPeriod, "policy-reload-period", 10*time.Second, "period of checking policy file for changes, 0 disables reloading")
 
 	flag.Parse()
 
@@ -39,6 +135,165 @@ func Parse() {
 	if a := os.Getenv("SERVER_ADDRESS"); a != "" {
 		opt.ServerAddress = a
 	}
+
+	if b := os.Getenv("BOLT_STORAGE_PATH"); b != "" {
+		opt.BoltStoragePath = b
+	}
+
+	if a := os.Getenv("CODE_ALPHABET"); a != "" {
+		opt.CodeAlphabet = a
+	}
+
+	if err := intFromEnv("CODE_LENGTH", &opt.CodeLength); err != nil {
+		return err
+	}
+
+	if g := os.Getenv("ID_GENERATOR"); g != "" {
+		opt.IDGenerator = g
+	}
+
+	if err := intFromEnv("NODE_ID", &opt.NodeID); err != nil {
+		return err
+	}
+
+	if err := intFromEnv("ID_BLOCK_SIZE", &opt.IDBlockSize); err != nil {
+		return err
+	}
+
+	if a := os.Getenv("ALLOWED_SCHEMES"); a != "" {
+		opt.AllowedSchemes = a
+	}
+
+	if err := boolFromEnv("SORT_QUERY", &opt.SortQuery); err != nil {
+		return err
+	}
+
+	if err := boolFromEnv("STRIP_FRAGMENT", &opt.StripFragment); err != nil {
+		return err
+	}
+
+	if p := os.Getenv("URL_POLICY_FILE"); p != "" {
+		opt.PolicyFile = p
+	}
+
+	if err := boolFromEnv("URL_POLICY_ALLOWLIST", &opt.PolicyAllowlist); err != nil {
+		return err
+	}
+
+	if err := durationFromEnv("URL_POLICY_RELOAD_PERIOD", &opt.PolicyReloadPeriod); err != nil {
+		return err
+	}
+
+	if v := os.Getenv("IP_HASH_SALT"); v != "" {
+		opt.IPHashSalt = v
+	}
+
+	if err := durationFromEnv("DELETED_RETENTION", &opt.DeletedRetention); err != nil {
+		return err
+	}
+
+	if err := durationFromEnv("IDEMPOTENCY_TTL", &opt.IdempotencyTTL); err != nil {
+		return err
+	}
+
+	if err := intFromEnv("IDEMPOTENCY_MAX_KEYS", &opt.IdempotencyMaxKeys); err != nil {
+		return err
+	}
+
+	if k := os.Getenv("SECRET_KEY"); k != "" {
+		opt.SecretKey = k
+	}
+
+	if f := os.Getenv("SECRET_KEY_FILE"); f != "" {
+		opt.SecretKeyFile = f
+	}
+
+	if err := durationFromEnv("AUTH_TOKEN_TTL", &opt.AuthTokenTTL); err != nil {
+		return err
+	}
+
+	if err := boolFromEnv("AUTH_LEGACY_TOKENS", &opt.AuthLegacyTokens); err != nil {
+		return err
+	}
+
+	if err := durationFromEnv("AUTH_RENEW_BEFORE", &opt.AuthRenewBefore); err != nil {
+		return err
+	}
+
+	if v := os.Getenv("COOKIE_SECURE"); v != "" {
+		opt.CookieSecure = v
+	}
+
+	if v := os.Getenv("COOKIE_SAMESITE"); v != "" {
+		opt.CookieSameSite = v
+	}
+
+	if v := os.Getenv("COOKIE_PATH"); v != "" {
+		opt.CookiePath = v
+	}
+
+	if v := os.Getenv("COOKIE_DOMAIN"); v != "" {
+		opt.CookieDomain = v
+	}
+
+	if err := durationFromEnv("SHUTDOWN_TIMEOUT", &opt.ShutdownTimeout); err != nil {
+		return err
+	}
+
+	if err := intFromEnv("CACHE_SIZE", &opt.CacheSize); err != nil {
+		return err
+	}
+
+	if err := durationFromEnv("CACHE_TTL", &opt.CacheTTL); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func intFromEnv(name string, dst *int) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("config: invalid %s: %w", name, err)
+	}
+
+	*dst = i
+	return nil
+}
+
+func boolFromEnv(name string, dst *bool) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("config: invalid %s: %w", name, err)
+	}
+
+	*dst = b
+	return nil
+}
+
+func durationFromEnv(name string, dst *time.Duration) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("config: invalid %s: %w", name, err)
+	}
+
+	*dst = d
+	return nil
 }
 
 // Get returns options.
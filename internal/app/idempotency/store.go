@@ -0,0 +1,165 @@
+// Package idempotency keeps responses of requests sent with an idempotency key,
+// so that a retried request gets the original response instead of being processed again.
+package idempotency
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Error when the key was used for a request with a different body.
+var ErrKeyReused = errors.New("idempotency key is used for another request")
+
+// Error when the request with the key has not finished yet.
+var ErrInProgress = errors.New("request with idempotency key is in progress")
+
+// Fingerprint identifies the request a key was used for.
+type Fingerprint [sha256.Size]byte
+
+// NewFingerprint computes fingerprint of a request from its method, path and body.
+func NewFingerprint(method, path string, body []byte) Fingerprint {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+
+	var f Fingerprint
+	copy(f[:], h.Sum(nil))
+	return f
+}
+
+// Response saved for a key.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+type entryKey struct {
+	userID string
+	key    string
+}
+
+type entry struct {
+	key         entryKey
+	fingerprint Fingerprint
+	// Nil while the request is in progress.
+	resp    *Response
+	expires time.Time
+	// Element of the entry in the order keys were reserved.
+	el *list.Element
+}
+
+// Store keeps responses in memory for a fixed window.
+// When it holds the maximum number of keys, the oldest one is dropped for a new one.
+type Store struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	maxKeys   int
+	entries   map[entryKey]*entry
+	order     *list.List
+	lastSweep time.Time
+
+	now func() time.Time
+}
+
+// NewStore creates store keeping responses for ttl, at most maxKeys of them if it is positive.
+func NewStore(ttl time.Duration, maxKeys int) *Store {
+	return &Store{
+		ttl:     ttl,
+		maxKeys: maxKeys,
+		entries: make(map[entryKey]*entry),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Begin reserves the key of a user for a request.
+// It returns the saved response if the same request has been completed,
+// and nil if the caller should process the request and then call Finish or Abort.
+func (s *Store) Begin(userID, key string, f Fingerprint) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	k := entryKey{userID: userID, key: key}
+	e, ok := s.entries[k]
+	if ok && now.After(e.expires) {
+		ok = false
+	}
+
+	switch {
+	case !ok:
+		s.add(&entry{key: k, fingerprint: f, expires: now.Add(s.ttl)})
+		return nil, nil
+	case e.fingerprint != f:
+		return nil, ErrKeyReused
+	case e.resp == nil:
+		return nil, ErrInProgress
+	}
+
+	return e.resp, nil
+}
+
+// Finish saves the response of a request started with Begin.
+func (s *Store) Finish(userID, key string, resp Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[entryKey{userID: userID, key: key}]; ok {
+		e.resp = &resp
+		e.expires = s.now().Add(s.ttl)
+	}
+}
+
+// Abort releases the key of a request started with Begin, so that it can be retried.
+func (s *Store) Abort(userID, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[entryKey{userID: userID, key: key}]; ok {
+		s.remove(e)
+	}
+}
+
+// add saves the entry in place of an expired one with the same key,
+// dropping the oldest entry if the store is full.
+func (s *Store) add(e *entry) {
+	if old, ok := s.entries[e.key]; ok {
+		s.remove(old)
+	}
+
+	if s.maxKeys > 0 && len(s.entries) >= s.maxKeys {
+		s.remove(s.order.Front().Value.(*entry))
+	}
+
+	e.el = s.order.PushBack(e)
+	s.entries[e.key] = e
+}
+
+func (s *Store) remove(e *entry) {
+	s.order.Remove(e.el)
+	delete(s.entries, e.key)
+}
+
+// sweep drops expired entries at most once per ttl.
+func (s *Store) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.ttl {
+		return
+	}
+	s.lastSweep = now
+
+	for _, e := range s.entries {
+		if now.After(e.expires) {
+			s.remove(e)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package idempotency
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewStore(time.Hour, 0)
+	s.now = func() time.Time { return now }
+
+	f := NewFingerprint(http.MethodPost, "/api/shorten", []byte(`{"url":"http://a.ru"}`))
+	other := NewFingerprint(http.MethodPost, "/api/shorten", []byte(`{"url":"http://b.ru"}`))
+
+	resp, err := s.Begin("user", "key", f)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	_, err = s.Begin("user", "key", f)
+	require.ErrorIs(t, err, ErrInProgress)
+
+	// Keys of different users do not interfere.
+	resp, err = s.Begin("another", "key", other)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	saved := Response{Status: http.StatusCreated, Body: []byte("body")}
+	s.Finish("user", "key", saved)
+
+	resp, err = s.Begin("user", "key", f)
+	require.NoError(t, err)
+	require.Equal(t, &saved, resp)
+
+	_, err = s.Begin("user", "key", other)
+	require.ErrorIs(t, err, ErrKeyReused)
+
+	// Aborted requests can be retried.
+	s.Abort("another", "key")
+	resp, err = s.Begin("another", "key", other)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	// Expired keys can be reused.
+	now = now.Add(2 * time.Hour)
+	resp, err = s.Begin("user", "key", other)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
+
+func TestStoreMaxKeys(t *testing.T) {
+	s := NewStore(time.Hour, 2)
+	f := NewFingerprint(http.MethodPost, "/api/shorten", []byte(`{"url":"http://a.ru"}`))
+	saved := Response{Status: http.StatusCreated, Body: []byte("body")}
+
+	for _, key := range []string{"a", "b"} {
+		_, err := s.Begin("user", key, f)
+		require.NoError(t, err)
+		s.Finish("user", key, saved)
+	}
+
+	// The oldest key is dropped for a new one.
+	resp, err := s.Begin("user", "c", f)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.Len(t, s.entries, 2)
+
+	resp, err = s.Begin("user", "b", f)
+	require.NoError(t, err)
+	require.Equal(t, &saved, resp)
+
+	resp, err = s.Begin("user", "a", f)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.Len(t, s.entries, 2)
+	require.Equal(t, s.order.Len(), len(s.entries))
+}
@@ -0,0 +1,98 @@
+package idgen
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+)
+
+// Time to wait for the database when reserving a block.
+const blockReserveTimeout = 5 * time.Second
+
+// BlockAllocator hands out sequential ids from blocks reserved in a Postgres sequence.
+// Every reserved block belongs to a single instance, so instances never give out the same id.
+type BlockAllocator struct {
+	mu   sync.Mutex
+	next uint64
+	end  uint64
+
+	size     uint64
+	reserve  func(ctx context.Context) (int64, error)
+	fallback interface {
+		Next() uint64
+	}
+	log *logger.Logger
+}
+
+// NewBlockAllocator creates allocator reserving blocks of size ids from the url_id_blocks sequence,
+// see migrations. If a block cannot be reserved, ids are taken from fallback until the database recovers.
+// The first block is reserved right away.
+func NewBlockAllocator(db *sql.DB, size uint64, fallback interface {
+	Next() uint64
+}, log *logger.Logger) (*BlockAllocator, error) {
+	reserve := func(ctx context.Context) (int64, error) {
+		var n int64
+		err := db.QueryRowContext(ctx, `select nextval('url_id_blocks')`).Scan(&n)
+		return n, err
+	}
+
+	return newBlockAllocator(reserve, size, fallback, log)
+}
+
+func newBlockAllocator(reserve func(ctx context.Context) (int64, error), size uint64, fallback interface {
+	Next() uint64
+}, log *logger.Logger) (*BlockAllocator, error) {
+	if size == 0 {
+		return nil, fmt.Errorf("idgen: block size must be positive")
+	}
+
+	g := &BlockAllocator{size: size, reserve: reserve, fallback: fallback, log: log}
+
+	if err := g.reserveBlock(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Next returns another id of the current block, reserving a new block when it is used up.
+func (g *BlockAllocator) Next() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.next == g.end {
+		if err := g.reserveBlock(); err != nil {
+			g.log.Errorf("falling back to another id generator: %v", err)
+			return g.fallback.Next()
+		}
+	}
+
+	id := g.next
+	g.next++
+
+	return id
+}
+
+// reserveBlock takes the next block from the sequence.
+// Block n holds ids from n*size to (n+1)*size-1.
+func (g *BlockAllocator) reserveBlock() error {
+	ctx, cancel := context.WithTimeout(context.Background(), blockReserveTimeout)
+	defer cancel()
+
+	n, err := g.reserve(ctx)
+	if err != nil {
+		return fmt.Errorf("idgen: failed to reserve id block: %w", err)
+	}
+	if n <= 0 {
+		return fmt.Errorf("idgen: unexpected id block number %d", n)
+	}
+
+	g.next = uint64(n) * g.size
+	g.end = g.next + g.size
+
+	return nil
+}
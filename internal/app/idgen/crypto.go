@@ -0,0 +1,25 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+)
+
+type cryptoGen struct{}
+
+// NewCrypto creates generator of cryptographically secure random values.
+// Its Next panics if the system source of randomness fails, predictable values are never returned.
+func NewCrypto() cryptoGen {
+	return cryptoGen{}
+}
+
+// Next returns another random value.
+// It panics if the system source of randomness fails.
+func (g cryptoGen) Next() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("idgen: crypto/rand failed: " + err.Error())
+	}
+
+	return binary.BigEndian.Uint64(b[:])
+}
@@ -0,0 +1,94 @@
+package idgen
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSnowflake(t *testing.T) {
+	now := snowflakeEpoch.Add(time.Second)
+
+	g, err := NewSnowflake(5)
+	require.NoError(t, err)
+	g.now = func() time.Time { return now }
+	g.sleep = func(d time.Duration) { now = now.Add(d) }
+
+	first := g.Next()
+	require.Equal(t, uint64(1000)<<22|5<<12, first)
+
+	prev := first
+	for i := 0; i < 2*maxSequence; i++ {
+		id := g.Next()
+		require.Greater(t, id, prev)
+		prev = id
+	}
+
+	// The sequence of the first millisecond is used up, so the clock has moved on.
+	require.True(t, now.After(snowflakeEpoch.Add(time.Second)))
+
+	// The clock going backwards does not produce repeated ids.
+	now = now.Add(-time.Minute)
+	require.Greater(t, g.Next(), prev)
+}
+
+func TestNewSnowflake(t *testing.T) {
+	_, err := NewSnowflake(MaxNode + 1)
+	require.Error(t, err)
+
+	_, err = NewSnowflake(-1)
+	require.Error(t, err)
+}
+
+type seqGen struct {
+	n uint64
+}
+
+func (g *seqGen) Next() uint64 {
+	g.n++
+	return g.n
+}
+
+func TestBlockAllocator(t *testing.T) {
+	log := logger.NewLogger(zap.NewNop())
+
+	var block int64
+	var reserveErr error
+	reserve := func(ctx context.Context) (int64, error) {
+		if reserveErr != nil {
+			return 0, reserveErr
+		}
+		block++
+		return block, nil
+	}
+
+	g, err := newBlockAllocator(reserve, 3, &seqGen{n: 100}, log)
+	require.NoError(t, err)
+
+	var ids []uint64
+	for i := 0; i < 4; i++ {
+		ids = append(ids, g.Next())
+	}
+	require.Equal(t, []uint64{3, 4, 5, 6}, ids)
+
+	reserveErr = errors.New("connection refused")
+	require.Equal(t, uint64(7), g.Next())
+	require.Equal(t, uint64(8), g.Next())
+	require.Equal(t, uint64(101), g.Next())
+
+	reserveErr = nil
+	require.Equal(t, uint64(9), g.Next())
+}
+
+func TestNewBlockAllocator(t *testing.T) {
+	log := logger.NewLogger(zap.NewNop())
+	reserve := func(ctx context.Context) (int64, error) { return 0, errors.New("connection refused") }
+
+	_, err := newBlockAllocator(reserve, 10, NewCrypto(), log)
+	require.Error(t, err)
+}
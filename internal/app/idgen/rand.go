@@ -1,4 +1,5 @@
-// Module generates pseudo-random integers (uint64).
+// Package idgen generates integer ids (uint64): pseudo-random, cryptographically random,
+// time-ordered with a node id, or sequential from blocks reserved in a database.
 package idgen
 
 import "math/rand"
@@ -0,0 +1,70 @@
+package idgen
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	nodeBits     = 10
+	sequenceBits = 12
+
+	// MaxNode is the largest node id of a snowflake generator.
+	MaxNode     = 1<<nodeBits - 1
+	maxSequence = 1<<sequenceBits - 1
+)
+
+// Start of the snowflake time, 2023-01-01 UTC.
+var snowflakeEpoch = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Snowflake generates ids from milliseconds since its epoch, node id and a sequence number
+// within a millisecond. Ids of different nodes never collide and grow with time.
+type Snowflake struct {
+	mu       sync.Mutex
+	node     uint64
+	lastMs   int64
+	sequence uint64
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewSnowflake creates snowflake generator for node with id from 0 to MaxNode.
+func NewSnowflake(node int) (*Snowflake, error) {
+	if node < 0 || node > MaxNode {
+		return nil, fmt.Errorf("idgen: node id %d is out of range [0, %d]", node, MaxNode)
+	}
+
+	return &Snowflake{node: uint64(node), now: time.Now, sleep: time.Sleep}, nil
+}
+
+// Next returns another id.
+// It waits for the next millisecond if the sequence of the current one is exhausted.
+func (g *Snowflake) Next() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := g.now().Sub(snowflakeEpoch).Milliseconds()
+
+	// The clock went backwards, keep counting in the last millisecond.
+	if ms < g.lastMs {
+		ms = g.lastMs
+	}
+
+	if ms == g.lastMs {
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 {
+			for ms <= g.lastMs {
+				g.sleep(time.Millisecond)
+				ms = g.now().Sub(snowflakeEpoch).Milliseconds()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+
+	g.lastMs = ms
+
+	return uint64(ms)<<(nodeBits+sequenceBits) | g.node<<sequenceBits | g.sequence
+}
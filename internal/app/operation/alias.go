@@ -0,0 +1,37 @@
+package operation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/KonBal/url-shortener/internal/app/base62"
+)
+
+const maxAliasLength = 64
+
+// Aliases that would shadow routes of the service.
+var reservedAliases = map[string]struct{}{
+	"api":   {},
+	"ping":  {},
+	"debug": {},
+}
+
+// validateAlias checks that alias can be used as a short url.
+// Alias may contain symbols of base62 alphabet, '-' and '_'.
+func validateAlias(alias string) error {
+	if len(alias) > maxAliasLength {
+		return invalidAliasError(fmt.Sprintf("alias must not be longer than %d symbols", maxAliasLength))
+	}
+
+	for _, r := range alias {
+		if !strings.ContainsRune(base62.Alphabet, r) && r != '-' && r != '_' {
+			return invalidAliasError(fmt.Sprintf("alias %s contains forbidden symbol %q", alias, r))
+		}
+	}
+
+	if _, ok := reservedAliases[strings.ToLower(alias)]; ok {
+		return invalidAliasError(fmt.Sprintf("alias %s is reserved", alias))
+	}
+
+	return nil
+}
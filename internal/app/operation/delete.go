@@ -3,8 +3,11 @@ package operation
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/KonBal/url-shortener/internal/app/logger"
@@ -16,7 +19,7 @@ import (
 type Delete struct {
 	Log     *logger.Logger
 	Service interface {
-		Delete(ctx context.Context, userID string, urls []string) error
+		Delete(ctx context.Context, userID string, urls []string) (string, error)
 	}
 }
 
@@ -33,79 +36,325 @@ func (o *Delete) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	s := session.FromContext(ctx)
 
-	if err := o.Service.Delete(ctx, s.UserID, urls); err != nil {
+	id, err := o.Service.Delete(ctx, s.UserID, urls)
+	switch {
+	case errors.Is(err, ErrTooLarge):
+		o.Log.RequestError(req, err)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, ErrUnavailable):
+		o.Log.RequestError(req, err)
+		w.Header().Set("Retry-After", deletionRetryAfter)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
 		o.Log.RequestError(req, err)
 		http.Error(w, "An error has occured", http.StatusInternalServerError)
 		return
 	}
 
+	resp := struct {
+		ID string `json:"id"`
+	}{
+		ID: id,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/user/deletions/"+id)
 	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		o.Log.RequestError(req, fmt.Errorf("write response body: %w", err))
+	}
 }
 
-// Worker that recieves entries through a channel and runs deletion operation periodically.
+// Seconds a client is asked to wait when the deletion queue is full.
+const deletionRetryAfter = "5"
+
+// Delays between attempts to save a failed batch of deletions.
+const (
+	deletionMinBackoff = 100 * time.Millisecond
+	deletionMaxBackoff = 30 * time.Second
+)
+
+// Worker that recieves entries through a bounded queue and runs deletion operation periodically.
+// Failed batches are retried until they are saved, so every accepted entry is deleted at least once.
 type DeletionWorker struct {
-	entriesCh  chan storage.EntryToDelete
+	lifecycle
+
+	// Guards sending to entriesCh, so that all urls of a request are queued or none.
+	mu       sync.Mutex
+	stopping bool
+
+	entriesCh  chan queuedDeletion
 	workPeriod time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
 	storage    storage.Storage
 	log        *logger.Logger
+
+	jobsMu    sync.Mutex
+	jobs      map[string]*deletionJob
+	lastSweep time.Time
+
+	batch    atomic.Int64
+	deleted  atomic.Int64
+	retries  atomic.Int64
+	rejected atomic.Int64
+}
+
+// Url of a deletion job waiting in the queue.
+type queuedDeletion struct {
+	entry storage.EntryToDelete
+	job   *deletionJob
+	idx   int
 }
 
-// NewDeletionWorker returns deletion worker.
+// Counters of deletion worker.
+type DeletionStats struct {
+	// Entries waiting in the queue.
+	Queued   int `json:"queued"`
+	Capacity int `json:"capacity"`
+	// Entries taken from the queue and not yet saved.
+	Batch    int64 `json:"batch"`
+	Deleted  int64 `json:"deleted"`
+	Retries  int64 `json:"retries"`
+	Rejected int64 `json:"rejected"`
+}
+
+// NewDeletionWorker returns deletion worker with a queue of bufSize entries.
 func NewDeletionWorker(s storage.Storage, log *logger.Logger,
 	bufSize, workPeriodSec int64) *DeletionWorker {
-	w := &DeletionWorker{
+	w := newDeletionWorker(s, log, bufSize, workPeriodSec)
+
+	go w.RunDeletion()
+
+	return w
+}
+
+func newDeletionWorker(s storage.Storage, log *logger.Logger,
+	bufSize, workPeriodSec int64) *DeletionWorker {
+	return &DeletionWorker{
+		lifecycle:  newLifecycle(),
 		storage:    s,
-		entriesCh:  make(chan storage.EntryToDelete, bufSize),
+		entriesCh:  make(chan queuedDeletion, bufSize),
 		workPeriod: time.Duration(workPeriodSec) * time.Second,
+		minBackoff: deletionMinBackoff,
+		maxBackoff: deletionMaxBackoff,
 		log:        log,
+		jobs:       make(map[string]*deletionJob),
 	}
+}
 
-	go w.RunDeletion()
+// Delete adds the entry urls to the queue of objects to be removed and returns id of the deletion job.
+// It fails with ErrTooLarge if there are more urls than the queue can ever hold,
+// and with ErrUnavailable if the queue has no room for all of them now or the worker is shutting down.
+func (w *DeletionWorker) Delete(ctx context.Context, userID string, urls []string) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	return w
-}
+	switch {
+	case len(urls) > cap(w.entriesCh):
+		w.rejected.Add(int64(len(urls)))
+		return "", tooLargeError(fmt.Sprintf("cannot delete %d urls at once, the limit is %d", len(urls), cap(w.entriesCh)))
+	case w.stopping:
+		w.rejected.Add(int64(len(urls)))
+		return "", unavailableError("deletion worker is shutting down")
+	case cap(w.entriesCh)-len(w.entriesCh) < len(urls):
+		w.rejected.Add(int64(len(urls)))
+		return "", unavailableError(fmt.Sprintf("deletion queue is full, cannot add %d urls", len(urls)))
+	}
 
-// Delete adds the entry url to the channel of objects to be removed.
-func (w *DeletionWorker) Delete(ctx context.Context, userID string, urls []string) error {
-	go func() {
-		for _, u := range urls {
-			w.entriesCh <- storage.EntryToDelete{ShortURL: u, UserID: userID}
-		}
-	}()
+	job, err := newDeletionJob(userID, urls)
+	if err != nil {
+		return "", err
+	}
+	if len(urls) == 0 {
+		job.finished = time.Now()
+	}
+
+	w.jobsMu.Lock()
+	w.sweepJobs(time.Now())
+	w.jobs[job.id] = job
+	w.jobsMu.Unlock()
 
-	return nil
+	// Only senders holding the lock fill the queue, so there is room for every url.
+	for i, u := range urls {
+		w.entriesCh <- queuedDeletion{entry: storage.EntryToDelete{ShortURL: u, UserID: userID}, job: job, idx: i}
+	}
+
+	return job.id, nil
 }
 
-// RunDeletion runs a job to delete entries marked for deletion when the channel if full or periodically.
-func (w *DeletionWorker) RunDeletion() {
-	ticker := time.NewTicker(w.workPeriod)
+// GetDeletion returns the state of a deletion job of the user.
+// Jobs are kept for an hour after they finish.
+func (w *DeletionWorker) GetDeletion(ctx context.Context, userID string, id string) (*DeletionJob, error) {
+	w.jobsMu.Lock()
+	defer w.jobsMu.Unlock()
 
-	var toDelete []storage.EntryToDelete
+	job, ok := w.jobs[id]
+	if !ok || job.userID != userID {
+		return nil, notFoundError(fmt.Sprintf("deletion job %s is not found", id))
+	}
 
-	saveAndReset := func() {
-		err := w.storage.MarkDeleted(context.TODO(), toDelete...)
-		if err != nil {
-			w.log.Errorf("failed to delete urls: %v", err)
-			return
+	return job.state(), nil
+}
+
+// sweepJobs drops jobs finished longer than deletionJobTTL ago, at most once per minute.
+func (w *DeletionWorker) sweepJobs(now time.Time) {
+	if now.Sub(w.lastSweep) < time.Minute {
+		return
+	}
+	w.lastSweep = now
+
+	for id, job := range w.jobs {
+		if job.remaining == 0 && now.Sub(job.finished) > deletionJobTTL {
+			delete(w.jobs, id)
 		}
+	}
+}
 
-		toDelete = nil
+// Shutdown stops accepting deletions and saves the queued ones.
+// If ctx expires first, retries of the pending batch are abandoned.
+func (w *DeletionWorker) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	w.stopping = true
+	w.mu.Unlock()
+
+	return w.lifecycle.Shutdown(ctx)
+}
+
+// Stats returns counters of the worker.
+func (w *DeletionWorker) Stats() DeletionStats {
+	return DeletionStats{
+		Queued:   len(w.entriesCh),
+		Capacity: cap(w.entriesCh),
+		Batch:    w.batch.Load(),
+		Deleted:  w.deleted.Load(),
+		Retries:  w.retries.Load(),
+		Rejected: w.rejected.Load(),
 	}
+}
+
+// RunDeletion runs a job to delete queued entries when a batch of queue size is collected or periodically.
+// When the worker is shut down, the queued entries are deleted before it returns.
+func (w *DeletionWorker) RunDeletion() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.workPeriod)
+	defer ticker.Stop()
+
+	var toDelete []queuedDeletion
 
 	for {
 		select {
 		case u := <-w.entriesCh:
+			toDelete = append(toDelete, u)
+			w.batch.Store(int64(len(toDelete)))
 			if len(toDelete) < cap(w.entriesCh) {
-				toDelete = append(toDelete, u)
-			} else {
-				saveAndReset()
+				continue
 			}
 		case <-ticker.C:
 			if len(toDelete) == 0 {
 				continue
 			}
+		case <-w.stop:
+			for len(w.entriesCh) > 0 {
+				toDelete = append(toDelete, <-w.entriesCh)
+			}
+			w.batch.Store(int64(len(toDelete)))
+
+			if len(toDelete) > 0 {
+				w.save(toDelete)
+			}
+			return
+		}
+
+		// The queue is not read while the batch is retried, so new deletions are rejected once it fills up.
+		w.save(toDelete)
+		toDelete = nil
+	}
+}
+
+// save marks the batch deleted, retrying with exponential backoff until it succeeds or the worker is cancelled.
+func (w *DeletionWorker) save(toDelete []queuedDeletion) {
+	backoff := w.minBackoff
+
+	for {
+		statuses, err := w.markDeleted(toDelete)
+		if err == nil {
+			w.resolve(toDelete, statuses)
+			w.batch.Store(0)
+			return
+		}
+
+		if w.ctx.Err() != nil {
+			w.log.Errorf("%d urls are not deleted, worker is cancelled: %v", len(toDelete), err)
+			w.resolve(toDelete, nil)
+			return
+		}
+
+		w.log.Errorf("failed to delete %d urls, retrying in %v: %v", len(toDelete), backoff, err)
+		w.retries.Add(1)
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-w.ctx.Done():
+			t.Stop()
+		}
+
+		if backoff *= 2; backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+	}
+}
+
+// markDeleted deletes urls owned by the users who requested their deletion
+// and returns the status of each url of the batch.
+// Urls that are missing or owned by someone else are rejected.
+func (w *DeletionWorker) markDeleted(toDelete []queuedDeletion) ([]string, error) {
+	entries := make([]storage.EntryToDelete, len(toDelete))
+	for i, q := range toDelete {
+		entries[i] = q.entry
+	}
 
-			saveAndReset()
+	owned, err := w.storage.MarkDeleted(w.ctx, entries...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The same code may be requested by several users in a batch, only its owner's request is deleted.
+	deleted := make(map[storage.EntryToDelete]struct{}, len(owned))
+	for _, u := range owned {
+		deleted[u] = struct{}{}
+	}
+
+	statuses := make([]string, len(toDelete))
+	for i, q := range toDelete {
+		statuses[i] = URLDeletionRejected
+		if _, ok := deleted[q.entry]; ok {
+			statuses[i] = URLDeletionDeleted
+		}
+	}
+
+	return statuses, nil
+}
+
+// resolve records statuses of the batch urls in their jobs, nil statuses mean the batch failed.
+func (w *DeletionWorker) resolve(toDelete []queuedDeletion, statuses []string) {
+	w.jobsMu.Lock()
+	defer w.jobsMu.Unlock()
+
+	now := time.Now()
+	for i, q := range toDelete {
+		status := URLDeletionFailed
+		if statuses != nil {
+			status = statuses[i]
+		}
+		if status == URLDeletionDeleted {
+			w.deleted.Add(1)
 		}
+
+		q.job.resolve(q.idx, status, now)
 	}
 }
@@ -0,0 +1,242 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+	"github.com/KonBal/url-shortener/internal/app/session"
+	"github.com/KonBal/url-shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type seqRand struct{ n uint64 }
+
+func (r *seqRand) Next() uint64 {
+	r.n++
+	return r.n
+}
+
+func TestDeletionWorkerFileStorage(t *testing.T) {
+	ctx := context.TODO()
+	log := logger.NewLogger(zap.NewNop())
+
+	st, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "urls.json"), &seqRand{})
+	require.NoError(t, err)
+	defer st.Close()
+
+	require.NoError(t, st.AddMany(ctx, []storage.URLEntry{
+		{ShortURL: "own", OriginalURL: "http://own.link"},
+		{ShortURL: "kept", OriginalURL: "http://kept.link"},
+	}, "user"))
+	require.NoError(t, st.Add(ctx, storage.URLEntry{ShortURL: "foreign", OriginalURL: "http://foreign.link"}, "another"))
+
+	worker := NewDeletionWorker(st, log, 16, 1)
+	id, err := worker.Delete(ctx, "user", []string{"own", "foreign", "missing"})
+	require.NoError(t, err)
+
+	_, err = worker.GetDeletion(ctx, "another", id)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.Eventually(t, func() bool {
+		job, err := worker.GetDeletion(ctx, "user", id)
+		return err == nil && job.Status == DeletionDone
+	}, 5*time.Second, 50*time.Millisecond)
+
+	job, err := worker.GetDeletion(ctx, "user", id)
+	require.NoError(t, err)
+	require.Equal(t, []DeletionResult{
+		{ShortURL: "own", Status: URLDeletionDeleted},
+		{ShortURL: "foreign", Status: URLDeletionRejected},
+		{ShortURL: "missing", Status: URLDeletionRejected},
+	}, job.Results)
+
+	router := chi.NewRouter()
+	router.Method(http.MethodGet, "/{short}", &Expand{Log: log, Service: ShortURLService{Storage: st}})
+
+	tests := map[string]struct {
+		short      string
+		wantStatus int
+	}{
+		"deleted":       {short: "own", wantStatus: http.StatusGone},
+		"not_deleted":   {short: "kept", wantStatus: http.StatusTemporaryRedirect},
+		"not_owned_url": {short: "foreign", wantStatus: http.StatusTemporaryRedirect},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+tt.short, nil))
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			require.Equal(t, tt.wantStatus, res.StatusCode)
+		})
+	}
+}
+
+func TestDeletionWorkerShutdown(t *testing.T) {
+	ctx := context.TODO()
+	log := logger.NewLogger(zap.NewNop())
+
+	st := storage.NewInMemory()
+	require.NoError(t, st.AddMany(ctx, []storage.URLEntry{
+		{ShortURL: "a", OriginalURL: "http://a.link"},
+		{ShortURL: "b", OriginalURL: "http://b.link"},
+	}, "user"))
+
+	// The period is long enough for nothing to be deleted before shutdown.
+	worker := NewDeletionWorker(st, log, 16, 3600)
+	_, err := worker.Delete(ctx, "user", []string{"a", "b"})
+	require.NoError(t, err)
+	require.NoError(t, worker.Shutdown(ctx))
+	require.NoError(t, worker.Shutdown(ctx))
+
+	for _, short := range []string{"a", "b"} {
+		u, err := st.GetByShort(ctx, short)
+		require.NoError(t, err)
+		require.True(t, u.Deleted)
+	}
+}
+
+func TestDeletionWorkerSameCodeInBatch(t *testing.T) {
+	ctx := context.TODO()
+
+	st := storage.NewInMemory()
+	require.NoError(t, st.Add(ctx, storage.URLEntry{ShortURL: "x", OriginalURL: "http://x.link"}, "owner"))
+
+	// Both jobs wait in the queue and are saved as one batch on shutdown.
+	worker := NewDeletionWorker(st, logger.NewLogger(zap.NewNop()), 16, 3600)
+	ownerJob, err := worker.Delete(ctx, "owner", []string{"x"})
+	require.NoError(t, err)
+	otherJob, err := worker.Delete(ctx, "other", []string{"x"})
+	require.NoError(t, err)
+	require.NoError(t, worker.Shutdown(ctx))
+
+	job, err := worker.GetDeletion(ctx, "owner", ownerJob)
+	require.NoError(t, err)
+	require.Equal(t, []DeletionResult{{ShortURL: "x", Status: URLDeletionDeleted}}, job.Results)
+
+	job, err = worker.GetDeletion(ctx, "other", otherJob)
+	require.NoError(t, err)
+	require.Equal(t, []DeletionResult{{ShortURL: "x", Status: URLDeletionRejected}}, job.Results)
+}
+
+// flakyStorage fails MarkDeleted the given number of times.
+type flakyStorage struct {
+	storage.Storage
+	failures atomic.Int64
+}
+
+func (s *flakyStorage) MarkDeleted(ctx context.Context, urls ...storage.EntryToDelete) ([]storage.EntryToDelete, error) {
+	if s.failures.Add(-1) >= 0 {
+		return nil, errors.New("storage is down")
+	}
+
+	return s.Storage.MarkDeleted(ctx, urls...)
+}
+
+func TestDeletionWorkerRetries(t *testing.T) {
+	ctx := context.TODO()
+
+	st := &flakyStorage{Storage: storage.NewInMemory()}
+	st.failures.Store(2)
+	require.NoError(t, st.Add(ctx, storage.URLEntry{ShortURL: "a", OriginalURL: "http://a.link"}, "user"))
+
+	worker := newDeletionWorker(st, logger.NewLogger(zap.NewNop()), 1, 3600)
+	worker.minBackoff = time.Millisecond
+	go worker.RunDeletion()
+
+	_, err := worker.Delete(ctx, "user", []string{"a"})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		u, err := st.GetByShort(ctx, "a")
+		return err == nil && u.Deleted
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, worker.Shutdown(ctx))
+
+	stats := worker.Stats()
+	require.Equal(t, int64(1), stats.Deleted)
+	require.Equal(t, int64(2), stats.Retries)
+}
+
+func TestDeletionWorkerShutdownTimeout(t *testing.T) {
+	ctx := context.TODO()
+
+	st := &flakyStorage{Storage: storage.NewInMemory()}
+	st.failures.Store(1 << 30)
+
+	worker := NewDeletionWorker(st, logger.NewLogger(zap.NewNop()), 16, 3600)
+	id, err := worker.Delete(ctx, "user", []string{"a"})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	require.ErrorIs(t, worker.Shutdown(ctx), context.DeadlineExceeded)
+
+	job, err := worker.GetDeletion(context.TODO(), "user", id)
+	require.NoError(t, err)
+	require.Equal(t, DeletionFailed, job.Status)
+
+	_, err = worker.Delete(context.TODO(), "user", []string{"b"})
+	require.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestDeleteQueueFull(t *testing.T) {
+	ctx := context.TODO()
+
+	// The worker is not run, so the queue is never read.
+	worker := newDeletionWorker(storage.NewInMemory(), logger.NewLogger(zap.NewNop()), 2, 3600)
+	h := &Delete{Log: logger.NewLogger(zap.NewNop()), Service: worker}
+
+	tests := []struct {
+		name string
+		body string
+
+		wantStatus int
+		wantQueued int
+	}{
+		{name: "accepted", body: `["a"]`, wantStatus: http.StatusAccepted, wantQueued: 1},
+		{name: "no_room_for_all", body: `["b","c"]`, wantStatus: http.StatusServiceUnavailable, wantQueued: 1},
+		{name: "fills_queue", body: `["b"]`, wantStatus: http.StatusAccepted, wantQueued: 2},
+		{name: "full", body: `["c"]`, wantStatus: http.StatusServiceUnavailable, wantQueued: 2},
+		{name: "more_than_capacity", body: `["c","d","e"]`, wantStatus: http.StatusRequestEntityTooLarge, wantQueued: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(tt.body))
+			req = req.WithContext(session.ContextWithSession(ctx, &session.Session{UserID: "user"}))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			require.Equal(t, tt.wantStatus, res.StatusCode)
+			switch tt.wantStatus {
+			case http.StatusAccepted:
+				require.True(t, strings.HasPrefix(res.Header.Get("Location"), "/api/user/deletions/"))
+			case http.StatusServiceUnavailable:
+				require.NotEmpty(t, res.Header.Get("Retry-After"))
+			}
+			require.Equal(t, tt.wantQueued, worker.Stats().Queued)
+		})
+	}
+
+	require.Equal(t, int64(6), worker.Stats().Rejected)
+}
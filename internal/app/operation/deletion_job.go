@@ -0,0 +1,134 @@
+package operation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+	"github.com/KonBal/url-shortener/internal/app/session"
+	"github.com/go-chi/chi/v5"
+)
+
+// Time finished deletion jobs can be looked up.
+const deletionJobTTL = time.Hour
+
+// Status of a deletion job.
+const (
+	DeletionQueued = "queued"
+	DeletionDone   = "done"
+	DeletionFailed = "failed"
+)
+
+// Status of a short url in a deletion job.
+const (
+	URLDeletionQueued   = "queued"
+	URLDeletionDeleted  = "deleted"
+	URLDeletionRejected = "rejected"
+	URLDeletionFailed   = "failed"
+)
+
+// Represents operation to get the state of a deletion job.
+type GetDeletion struct {
+	Log     *logger.Logger
+	Service interface {
+		GetDeletion(ctx context.Context, userID string, id string) (*DeletionJob, error)
+	}
+}
+
+// ServeHTTP handles operation to get the state of a deletion job.
+func (o *GetDeletion) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	id := chi.URLParam(req, "id")
+
+	ctx := req.Context()
+	s := session.FromContext(ctx)
+
+	job, err := o.Service.GetDeletion(ctx, s.UserID, id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		o.Log.RequestError(req, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		o.Log.RequestError(req, err)
+		http.Error(w, "An error has occured", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		o.Log.RequestError(req, fmt.Errorf("write response body: %w", err))
+	}
+}
+
+// State of a deletion job.
+type DeletionJob struct {
+	ID      string           `json:"id"`
+	Status  string           `json:"status"`
+	Results []DeletionResult `json:"results"`
+}
+
+// State of a short url in a deletion job.
+type DeletionResult struct {
+	ShortURL string `json:"short_url"`
+	Status   string `json:"status"`
+}
+
+// deletionJob tracks urls of one delete request. It is guarded by the jobs mutex of the worker.
+type deletionJob struct {
+	id        string
+	userID    string
+	results   []DeletionResult
+	remaining int
+	finished  time.Time
+}
+
+func newDeletionJob(userID string, urls []string) (*deletionJob, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("deletion: failed to generate job id: %w", err)
+	}
+
+	j := &deletionJob{id: hex.EncodeToString(b), userID: userID, remaining: len(urls)}
+	for _, u := range urls {
+		j.results = append(j.results, DeletionResult{ShortURL: u, Status: URLDeletionQueued})
+	}
+
+	return j, nil
+}
+
+// resolve sets the status of the url at idx.
+func (j *deletionJob) resolve(idx int, status string, now time.Time) {
+	j.results[idx].Status = status
+	j.remaining--
+
+	if j.remaining == 0 {
+		j.finished = now
+	}
+}
+
+func (j *deletionJob) state() *DeletionJob {
+	status := DeletionDone
+	switch {
+	case j.remaining > 0:
+		status = DeletionQueued
+	default:
+		for _, r := range j.results {
+			if r.Status == URLDeletionFailed {
+				status = DeletionFailed
+				break
+			}
+		}
+	}
+
+	results := make([]DeletionResult, len(j.results))
+	copy(results, j.results)
+
+	return &DeletionJob{ID: j.id, Status: status, Results: results}
+}
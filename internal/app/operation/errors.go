@@ -42,3 +42,153 @@ func (e deletedError) Error() string {
 func (e deletedError) Is(target error) bool {
 	return target == ErrDeleted
 }
+
+// Error Invalid Alias.
+var ErrInvalidAlias error = errors.New("invalid alias")
+
+type invalidAliasError string
+
+// Error returns string for error.
+func (e invalidAliasError) Error() string {
+	return string(e)
+}
+
+// Is checks that the target is Invalid Alias.
+func (e invalidAliasError) Is(target error) bool {
+	return target == ErrInvalidAlias
+}
+
+// Error Alias Taken.
+var ErrAliasTaken error = errors.New("alias taken")
+
+type aliasTakenError string
+
+// Error returns string for error.
+func (e aliasTakenError) Error() string {
+	return string(e)
+}
+
+// Is checks that the target is Alias Taken.
+func (e aliasTakenError) Is(target error) bool {
+	return target == ErrAliasTaken
+}
+
+// Error Invalid Limit.
+var ErrInvalidLimit error = errors.New("invalid limit")
+
+type invalidLimitError string
+
+// Error returns string for error.
+func (e invalidLimitError) Error() string {
+	return string(e)
+}
+
+// Is checks that the target is Invalid Limit.
+func (e invalidLimitError) Is(target error) bool {
+	return target == ErrInvalidLimit
+}
+
+// Error Expired.
+var ErrExpired error = errors.New("expired")
+
+type expiredError string
+
+// Error returns string for error.
+func (e expiredError) Error() string {
+	return string(e)
+}
+
+// Is checks that the target is Expired.
+func (e expiredError) Is(target error) bool {
+	return target == ErrExpired
+}
+
+// Error Forbidden.
+var ErrForbidden error = errors.New("forbidden")
+
+type forbiddenError string
+
+// Error returns string for error.
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+
+// Is checks that the target is Forbidden.
+func (e forbiddenError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
+// Error Invalid URL.
+var ErrInvalidURL error = errors.New("invalid url")
+
+type invalidURLError string
+
+// Error returns string for error.
+func (e invalidURLError) Error() string {
+	return string(e)
+}
+
+// Is checks that the target is Invalid URL.
+func (e invalidURLError) Is(target error) bool {
+	return target == ErrInvalidURL
+}
+
+// Error Policy Violation.
+var ErrPolicyViolation error = errors.New("policy violation")
+
+type policyViolationError string
+
+// Error returns string for error.
+func (e policyViolationError) Error() string {
+	return string(e)
+}
+
+// Is checks that the target is Policy Violation.
+func (e policyViolationError) Is(target error) bool {
+	return target == ErrPolicyViolation
+}
+
+// Error Invalid Query.
+var ErrInvalidQuery error = errors.New("invalid query")
+
+type invalidQueryError string
+
+// Error returns string for error.
+func (e invalidQueryError) Error() string {
+	return string(e)
+}
+
+// Is checks that the target is Invalid Query.
+func (e invalidQueryError) Is(target error) bool {
+	return target == ErrInvalidQuery
+}
+
+// Error Unavailable.
+var ErrUnavailable error = errors.New("unavailable")
+
+type unavailableError string
+
+// Error returns string for error.
+func (e unavailableError) Error() string {
+	return string(e)
+}
+
+// Is checks that the target is Unavailable.
+func (e unavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// Error Too Large.
+var ErrTooLarge error = errors.New("too large")
+
+type tooLargeError string
+
+// Error returns string for error.
+func (e tooLargeError) Error() string {
+	return string(e)
+}
+
+// Is checks that the target is Too Large.
+func (e tooLargeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/KonBal/url-shortener/internal/app/logger"
 	"github.com/KonBal/url-shortener/internal/app/storage"
@@ -17,6 +18,10 @@ type Expand struct {
 	Service interface {
 		Expand(ctx context.Context, shortened string) (string, error)
 	}
+	// Optional, records clicks on resolved urls.
+	Clicks interface {
+		Record(shortURL string, req *http.Request)
+	}
 }
 
 // ServeHTTP hangles expand request.
@@ -30,7 +35,7 @@ func (o *Expand) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		o.Log.RequestError(req, err)
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
-	case errors.Is(err, ErrDeleted):
+	case errors.Is(err, ErrDeleted), errors.Is(err, ErrExpired):
 		o.Log.RequestError(req, err)
 		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
 		return
@@ -40,6 +45,10 @@ func (o *Expand) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if o.Clicks != nil {
+		o.Clicks.Record(shortened, req)
+	}
+
 	w.Header().Set("Content-Type", "text/plain")
 	w.Header().Set("Location", url)
 	w.WriteHeader(http.StatusTemporaryRedirect)
@@ -59,5 +68,19 @@ func (s ShortURLService) Expand(ctx context.Context, shortened string) (string,
 		return "", deletedError(fmt.Sprintf("url for shortened %s is already deleted", shortened))
 	}
 
+	if u.ExpiresAt != nil && !time.Now().Before(*u.ExpiresAt) {
+		return "", expiredError(fmt.Sprintf("url for shortened %s has expired", shortened))
+	}
+
+	if u.MaxClicks > 0 {
+		err := s.Storage.CountClick(ctx, shortened)
+		switch {
+		case errors.Is(err, storage.ErrClickLimitReached):
+			return "", expiredError(fmt.Sprintf("url for shortened %s has reached its click limit", shortened))
+		case err != nil:
+			return "", fmt.Errorf("expand: failed to count click: %w", err)
+		}
+	}
+
 	return u.OriginalURL, nil
 }
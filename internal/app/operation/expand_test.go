@@ -3,6 +3,7 @@ package operation
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/KonBal/url-shortener/internal/app/storage"
 	"github.com/stretchr/testify/require"
@@ -10,6 +11,8 @@ import (
 
 func TestExpand(t *testing.T) {
 	baseURL := "http://base"
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
 
 	tests := map[string]struct {
 		short string
@@ -37,6 +40,28 @@ func TestExpand(t *testing.T) {
 			wantErr:         true,
 			expectedErr:     deletedError("url for shortened abcd is already deleted").Error(),
 		},
+		"not_expired": {
+			short:           "abcd",
+			existingEntries: []storage.URLEntry{{ShortURL: "abcd", OriginalURL: "http://orig.link", ExpiresAt: &future}},
+			want:            "http://orig.link",
+		},
+		"expired": {
+			short:           "abcd",
+			existingEntries: []storage.URLEntry{{ShortURL: "abcd", OriginalURL: "http://orig.link", ExpiresAt: &past}},
+			wantErr:         true,
+			expectedErr:     expiredError("url for shortened abcd has expired").Error(),
+		},
+		"last_click": {
+			short:           "abcd",
+			existingEntries: []storage.URLEntry{{ShortURL: "abcd", OriginalURL: "http://orig.link", MaxClicks: 2, Clicks: 1}},
+			want:            "http://orig.link",
+		},
+		"click_limit_reached": {
+			short:           "abcd",
+			existingEntries: []storage.URLEntry{{ShortURL: "abcd", OriginalURL: "http://orig.link", MaxClicks: 2, Clicks: 2}},
+			wantErr:         true,
+			expectedErr:     expiredError("url for shortened abcd has reached its click limit").Error(),
+		},
 	}
 
 	for name, tt := range tests {
@@ -0,0 +1,95 @@
+package operation
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Schemes allowed by URLNormalizer with no schemes configured.
+var defaultSchemes = []string{"http", "https"}
+
+// Ports omitted from normalized urls of the scheme.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Validates urls before shortening and brings them to a canonical form,
+// so the same link is not shortened twice.
+type URLNormalizer struct {
+	// Allowed url schemes, http and https if empty.
+	Schemes []string
+	// Sort query parameters by name.
+	SortQuery bool
+	// Drop the fragment after '#'.
+	StripFragment bool
+}
+
+// Normalize returns canonical form of rawURL or error if it is not allowed.
+func (n URLNormalizer) Normalize(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", invalidURLError("url is empty")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", invalidURLError(fmt.Sprintf("url %q cannot be parsed: %v", rawURL, err))
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !n.schemeAllowed(u.Scheme) {
+		return "", invalidURLError(fmt.Sprintf("url %q has scheme %q, allowed schemes are %s",
+			rawURL, u.Scheme, strings.Join(n.schemes(), ", ")))
+	}
+
+	if u.Host == "" {
+		return "", invalidURLError(fmt.Sprintf("url %q has no host", rawURL))
+	}
+
+	host, port := u.Hostname(), u.Port()
+	host = strings.ToLower(host)
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		// IPv6 address.
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	if n.SortQuery && u.RawQuery != "" {
+		// Encode sorts parameters by name.
+		u.RawQuery = u.Query().Encode()
+	}
+
+	if n.StripFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	return u.String(), nil
+}
+
+func (n URLNormalizer) schemes() []string {
+	if len(n.Schemes) == 0 {
+		return defaultSchemes
+	}
+	return n.Schemes
+}
+
+func (n URLNormalizer) schemeAllowed(scheme string) bool {
+	for _, s := range n.schemes() {
+		if strings.EqualFold(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
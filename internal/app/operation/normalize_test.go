@@ -0,0 +1,95 @@
+package operation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLNormalizerNormalize(t *testing.T) {
+	tests := map[string]struct {
+		normalizer URLNormalizer
+		url        string
+
+		want    string
+		wantErr bool
+	}{
+		"unchanged": {
+			url:  "https://example.com/Path?b=2&a=1#Top",
+			want: "https://example.com/Path?b=2&a=1#Top",
+		},
+		"whitespace": {
+			url:  "\t http://example.com/ \n",
+			want: "http://example.com/",
+		},
+		"host_case": {
+			url:  "HTTP://User@EXAMPLE.com/Path",
+			want: "http://User@example.com/Path",
+		},
+		"default_port": {
+			url:  "https://example.com:443/",
+			want: "https://example.com/",
+		},
+		"other_port": {
+			url:  "https://example.com:8443/",
+			want: "https://example.com:8443/",
+		},
+		"ipv6": {
+			url:  "http://[::1]:80/",
+			want: "http://[::1]/",
+		},
+		"sorted_query": {
+			normalizer: URLNormalizer{SortQuery: true},
+			url:        "http://example.com/?b=2&a=1&a=0",
+			want:       "http://example.com/?a=1&a=0&b=2",
+		},
+		"stripped_fragment": {
+			normalizer: URLNormalizer{StripFragment: true},
+			url:        "http://example.com/#top",
+			want:       "http://example.com/",
+		},
+		"configured_scheme": {
+			normalizer: URLNormalizer{Schemes: []string{"ftp"}},
+			url:        "FTP://example.com/file",
+			want:       "ftp://example.com/file",
+		},
+		"empty": {
+			url:     "  ",
+			wantErr: true,
+		},
+		"no_scheme": {
+			url:     "example.com",
+			wantErr: true,
+		},
+		"javascript": {
+			url:     "javascript:alert(1)",
+			wantErr: true,
+		},
+		"not_configured_scheme": {
+			normalizer: URLNormalizer{Schemes: []string{"https"}},
+			url:        "http://example.com",
+			wantErr:    true,
+		},
+		"no_host": {
+			url:     "http:///path",
+			wantErr: true,
+		},
+		"unparsable": {
+			url:     "http://exa mple.com/%zz",
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tt.normalizer.Normalize(tt.url)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidURL)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
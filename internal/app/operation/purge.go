@@ -0,0 +1,85 @@
+package operation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+	"github.com/KonBal/url-shortener/internal/app/storage"
+)
+
+// Worker that periodically removes urls which have been deleted for longer than the retention period.
+type PurgeWorker struct {
+	lifecycle
+
+	storage    storage.Storage
+	retention  time.Duration
+	batchSize  int
+	workPeriod time.Duration
+	log        *logger.Logger
+
+	now func() time.Time
+}
+
+// NewPurgeWorker returns purge worker.
+func NewPurgeWorker(s storage.Storage, log *logger.Logger,
+	retention time.Duration, batchSize int, workPeriod time.Duration) *PurgeWorker {
+	w := &PurgeWorker{
+		lifecycle:  newLifecycle(),
+		storage:    s,
+		retention:  retention,
+		batchSize:  batchSize,
+		workPeriod: workPeriod,
+		log:        log,
+		now:        time.Now,
+	}
+
+	go w.RunPurging()
+
+	return w
+}
+
+// Purge removes urls deleted before the retention period in batches and returns their number.
+func (w *PurgeWorker) Purge(ctx context.Context) (int, error) {
+	deletedBefore := w.now().Add(-w.retention)
+
+	total := 0
+	for {
+		purged, err := w.storage.PurgeDeleted(ctx, deletedBefore, w.batchSize)
+		total += len(purged)
+		if err != nil {
+			return total, fmt.Errorf("purge: failed to purge deleted urls: %w", err)
+		}
+
+		if len(purged) < w.batchSize {
+			return total, nil
+		}
+	}
+}
+
+// RunPurging runs a job to purge deleted urls periodically until the worker is shut down.
+func (w *PurgeWorker) RunPurging() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.workPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		n, err := w.Purge(w.ctx)
+		if err != nil {
+			w.log.Errorf("purged %d deleted urls before failure: %v", n, err)
+			continue
+		}
+
+		if n > 0 {
+			w.log.Infof("purged %d deleted urls", n)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package operation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeWorkerPurge(t *testing.T) {
+	ctx := context.TODO()
+	st := storage.NewInMemory()
+
+	var entries []storage.URLEntry
+	var toDelete []storage.EntryToDelete
+	for _, short := range []string{"a", "b", "c", "d", "e"} {
+		entries = append(entries, storage.URLEntry{ShortURL: short, OriginalURL: "http://" + short + ".link"})
+		toDelete = append(toDelete, storage.EntryToDelete{ShortURL: short, UserID: "user"})
+	}
+	require.NoError(t, st.AddMany(ctx, entries, "user"))
+	require.NoError(t, st.Add(ctx, storage.URLEntry{ShortURL: "kept", OriginalURL: "http://kept.link"}, "user"))
+	_, err := st.MarkDeleted(ctx, toDelete...)
+	require.NoError(t, err)
+
+	now := time.Now()
+	w := &PurgeWorker{storage: st, retention: time.Hour, batchSize: 2, now: func() time.Time { return now }}
+
+	n, err := w.Purge(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	now = now.Add(time.Hour + time.Second)
+
+	n, err = w.Purge(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	urls, err := st.GetURLsCreatedBy(ctx, "user", storage.URLFilter{})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	require.Equal(t, "kept", urls[0].ShortURL)
+}
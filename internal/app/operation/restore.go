@@ -0,0 +1,57 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+	"github.com/KonBal/url-shortener/internal/app/session"
+	"github.com/KonBal/url-shortener/internal/app/storage"
+)
+
+// Represents operation to restore deleted urls of user.
+type Restore struct {
+	Log     *logger.Logger
+	Service interface {
+		Restore(ctx context.Context, userID string, urls []string) error
+	}
+}
+
+// ServeHTTP handles operation to restore deleted urls of user.
+func (o *Restore) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var urls []string
+
+	if err := json.NewDecoder(req.Body).Decode(&urls); err != nil {
+		o.Log.RequestError(req, fmt.Errorf("read request body: %w", err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	ctx := req.Context()
+	s := session.FromContext(ctx)
+
+	if err := o.Service.Restore(ctx, s.UserID, urls); err != nil {
+		o.Log.RequestError(req, err)
+		http.Error(w, "An error has occured", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Restore clears deleted flag of the urls added by the user.
+// Urls of other users and urls which are not deleted are skipped.
+func (s ShortURLService) Restore(ctx context.Context, userID string, urls []string) error {
+	entries := make([]storage.EntryToDelete, 0, len(urls))
+	for _, u := range urls {
+		entries = append(entries, storage.EntryToDelete{ShortURL: u, UserID: userID})
+	}
+
+	if err := s.Storage.RestoreDeleted(ctx, entries...); err != nil {
+		return fmt.Errorf("restore: failed to restore urls: %w", err)
+	}
+
+	return nil
+}
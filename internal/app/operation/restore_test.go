@@ -0,0 +1,52 @@
+package operation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/KonBal/url-shortener/internal/app/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestore(t *testing.T) {
+	ctx := context.TODO()
+	st := storage.NewInMemory()
+
+	require.NoError(t, st.AddMany(ctx, []storage.URLEntry{
+		{ShortURL: "own", OriginalURL: "http://own.link"},
+		{ShortURL: "kept", OriginalURL: "http://kept.link"},
+	}, "user"))
+	require.NoError(t, st.Add(ctx, storage.URLEntry{ShortURL: "foreign", OriginalURL: "http://foreign.link"}, "another"))
+	_, err := st.MarkDeleted(ctx,
+		storage.EntryToDelete{ShortURL: "own", UserID: "user"},
+		storage.EntryToDelete{ShortURL: "kept", UserID: "user"},
+		storage.EntryToDelete{ShortURL: "foreign", UserID: "another"},
+	)
+	require.NoError(t, err)
+
+	s := ShortURLService{BaseURL: "http://base", Storage: st}
+
+	page, err := s.GetUserURLs(ctx, "user", URLQuery{})
+	require.NoError(t, err)
+	require.Empty(t, page.URLs)
+
+	page, err = s.GetUserURLs(ctx, "user", URLQuery{IncludeDeleted: true})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 2)
+	for _, u := range page.URLs {
+		require.True(t, u.Deleted)
+		require.NotNil(t, u.DeletedAt)
+	}
+
+	require.NoError(t, s.Restore(ctx, "user", []string{"own", "foreign", "missing"}))
+
+	page, err = s.GetUserURLs(ctx, "user", URLQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 1)
+	require.Equal(t, "http://base/own", page.URLs[0].ShortURL)
+	require.False(t, page.URLs[0].Deleted)
+
+	foreign, err := st.GetByShort(ctx, "foreign")
+	require.NoError(t, err)
+	require.True(t, foreign.Deleted)
+}
@@ -2,67 +2,255 @@ package operation
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/KonBal/url-shortener/internal/app/logger"
 	"github.com/KonBal/url-shortener/internal/app/session"
+	"github.com/KonBal/url-shortener/internal/app/storage"
+)
+
+// Number of urls in a page if a cursor is given without the limit, and the largest allowed limit.
+// Requests with neither get all urls, as they did before paging was added.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
 )
 
 // Represents operation to get urls of user.
 type GetUserURLs struct {
-	Log     *logger.Logger
+	Log *logger.Logger
+	// Base of the absolute link to the next page.
+	BaseURL string
 	Service interface {
-		GetUserURLs(ctx context.Context, userID string) ([]SavedURL, error)
+		GetUserURLs(ctx context.Context, userID string, q URLQuery) (*URLPage, error)
 	}
 }
 
 // ServeHTTP handles operation to get urls of user.
+// The page is written as JSON array, the cursor of the next page is returned
+// in X-Next-Cursor and Link headers. With envelope=true the page is written as
+// an object with urls and next_cursor fields, for clients that cannot read headers.
 func (o *GetUserURLs) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	q, err := parseURLQuery(req.URL.Query())
+	if err != nil {
+		o.Log.RequestError(req, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var envelope bool
+	if v := req.URL.Query().Get("envelope"); v != "" {
+		if envelope, err = strconv.ParseBool(v); err != nil {
+			o.Log.RequestError(req, err)
+			http.Error(w, "envelope must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+
 	ctx := req.Context()
 	s := session.FromContext(ctx)
 
-	resp, err := o.Service.GetUserURLs(ctx, s.UserID)
-	if err != nil {
+	page, err := o.Service.GetUserURLs(ctx, s.UserID, q)
+	switch {
+	case errors.Is(err, ErrInvalidQuery):
+		o.Log.RequestError(req, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
 		o.Log.RequestError(req, err)
 		http.Error(w, "An error has occured", http.StatusInternalServerError)
 		return
 	}
 
-	if len(resp) == 0 {
+	if page.NextCursor != "" {
+		next := req.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+		link := resolveURL(o.BaseURL, strings.TrimPrefix(req.URL.Path, "/")) + "?" + next.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, link))
+	}
+
+	if envelope {
+		resp := struct {
+			URLs       []SavedURL `json:"urls"`
+			NextCursor string     `json:"next_cursor,omitempty"`
+		}{
+			URLs:       page.URLs,
+			NextCursor: page.NextCursor,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			o.Log.RequestError(req, fmt.Errorf("write response body: %w", err))
+		}
+		return
+	}
+
+	if len(page.URLs) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
+	if err := json.NewEncoder(w).Encode(page.URLs); err != nil {
 		o.Log.RequestError(req, fmt.Errorf("write response body: %w", err))
 	}
 }
 
+func parseURLQuery(values url.Values) (URLQuery, error) {
+	q := URLQuery{
+		Cursor: values.Get("cursor"),
+		Search: values.Get("search"),
+	}
+	if q.Cursor != "" {
+		q.Limit = defaultPageSize
+	}
+
+	if v := values.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 || l > maxPageSize {
+			return q, invalidQueryError(fmt.Sprintf("limit must be a number from 1 to %d", maxPageSize))
+		}
+		q.Limit = l
+	}
+
+	for name, dst := range map[string]*time.Time{
+		"created_after":  &q.CreatedAfter,
+		"created_before": &q.CreatedBefore,
+	} {
+		if v := values.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, invalidQueryError(fmt.Sprintf("%s must be a time in RFC 3339 format", name))
+			}
+			*dst = t
+		}
+	}
+
+	if v := values.Get("include_deleted"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, invalidQueryError("include_deleted must be a boolean")
+		}
+		q.IncludeDeleted = b
+	}
+
+	return q, nil
+}
+
+// Parameters of the list of user urls.
+type URLQuery struct {
+	// Maximum number of urls in the page, all urls if not positive.
+	Limit int
+	// Opaque position returned with the previous page, the first page if empty.
+	Cursor string
+	// Only urls created after or before the time if set.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Only urls with original url containing the substring.
+	Search string
+	// Include urls marked deleted.
+	IncludeDeleted bool
+}
+
+// Page of user urls.
+type URLPage struct {
+	URLs []SavedURL
+	// Cursor of the next page, empty for the last page.
+	NextCursor string
+}
+
 // Represents urls saved in the system.
 type SavedURL struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	Deleted     bool       `json:"deleted,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 }
 
-// GetUserURLs returns URLs add by the user.
-func (s ShortURLService) GetUserURLs(ctx context.Context, userID string) ([]SavedURL, error) {
-	urls, err := s.Storage.GetURLsCreatedBy(ctx, userID)
+// Encoded form of storage.URLCursor.
+type urlCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ShortURL  string    `json:"s"`
+}
+
+func encodeCursor(c storage.URLCursor) string {
+	data, _ := json.Marshal(urlCursor{CreatedAt: c.CreatedAt, ShortURL: c.ShortURL})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*storage.URLCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalidQueryError("cursor is malformed")
+	}
+
+	var c urlCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ShortURL == "" {
+		return nil, invalidQueryError("cursor is malformed")
+	}
+
+	return &storage.URLCursor{CreatedAt: c.CreatedAt, ShortURL: c.ShortURL}, nil
+}
+
+// GetUserURLs returns a page of URLs add by the user.
+func (s ShortURLService) GetUserURLs(ctx context.Context, userID string, q URLQuery) (*URLPage, error) {
+	filter := storage.URLFilter{
+		CreatedAfter:   q.CreatedAfter,
+		CreatedBefore:  q.CreatedBefore,
+		Search:         q.Search,
+		IncludeDeleted: q.IncludeDeleted,
+	}
+
+	if q.Cursor != "" {
+		after, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter.After = after
+	}
+
+	// One more url is requested to find out if there is a next page.
+	if q.Limit > 0 {
+		filter.Limit = q.Limit + 1
+	}
+
+	urls, err := s.Storage.GetURLsCreatedBy(ctx, userID, filter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user urls: %w", err)
 	}
 
-	res := make([]SavedURL, 0, len(urls))
+	page := &URLPage{URLs: make([]SavedURL, 0, len(urls))}
+
+	if q.Limit > 0 && len(urls) > q.Limit {
+		urls = urls[:q.Limit]
+		last := urls[len(urls)-1]
+		page.NextCursor = encodeCursor(storage.URLCursor{CreatedAt: last.CreatedAt, ShortURL: last.ShortURL})
+	}
+
 	for _, u := range urls {
-		if !u.Deleted {
-			res = append(res, SavedURL{
-				ShortURL:    resolveURL(s.BaseURL, u.ShortURL),
-				OriginalURL: u.OriginalURL,
-			})
+		saved := SavedURL{
+			ShortURL:    resolveURL(s.BaseURL, u.ShortURL),
+			OriginalURL: u.OriginalURL,
+			Deleted:     u.Deleted,
+			DeletedAt:   u.DeletedAt,
+		}
+		if !u.CreatedAt.IsZero() {
+			createdAt := u.CreatedAt
+			saved.CreatedAt = &createdAt
 		}
+
+		page.URLs = append(page.URLs, saved)
 	}
 
-	return res, nil
+	return page, nil
 }
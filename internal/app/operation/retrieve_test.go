@@ -0,0 +1,203 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+	"github.com/KonBal/url-shortener/internal/app/session"
+	"github.com/KonBal/url-shortener/internal/app/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestGetUserURLsPages(t *testing.T) {
+	ctx := context.TODO()
+	st := storage.NewInMemory()
+
+	for _, short := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, st.Add(ctx, storage.URLEntry{ShortURL: short, OriginalURL: "http://" + short + ".link"}, "user"))
+	}
+
+	s := ShortURLService{BaseURL: "http://base", Storage: st}
+
+	var got []string
+	q := URLQuery{Limit: 2}
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+
+		page, err := s.GetUserURLs(ctx, "user", q)
+		require.NoError(t, err)
+
+		for _, u := range page.URLs {
+			got = append(got, u.ShortURL)
+			require.NotNil(t, u.CreatedAt)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+
+	require.ElementsMatch(t, []string{
+		"http://base/a", "http://base/b", "http://base/c", "http://base/d", "http://base/e",
+	}, got)
+
+	_, err := s.GetUserURLs(ctx, "user", URLQuery{Cursor: "not a cursor"})
+	require.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestParseURLQueryLimit(t *testing.T) {
+	tests := map[string]struct {
+		query     string
+		wantLimit int
+	}{
+		"no_limit":           {query: "", wantLimit: 0},
+		"limit":              {query: "limit=5", wantLimit: 5},
+		"cursor":             {query: "cursor=abc", wantLimit: defaultPageSize},
+		"cursor_and_limit":   {query: "cursor=abc&limit=5", wantLimit: 5},
+		"search_is_no_limit": {query: "search=a", wantLimit: 0},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+
+			q, err := parseURLQuery(values)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantLimit, q.Limit)
+		})
+	}
+}
+
+func TestGetUserURLsServeHTTP(t *testing.T) {
+	ctx := context.TODO()
+	st := storage.NewInMemory()
+
+	for _, short := range []string{"a", "b", "c"} {
+		require.NoError(t, st.Add(ctx, storage.URLEntry{ShortURL: short, OriginalURL: "http://" + short + ".link"}, "user"))
+	}
+
+	o := &GetUserURLs{
+		Log:     logger.NewLogger(zap.NewNop()),
+		BaseURL: "http://base",
+		Service: ShortURLService{BaseURL: "http://base", Storage: st},
+	}
+
+	tests := map[string]struct {
+		query    string
+		envelope bool
+
+		wantStatus int
+		wantLen    int
+		wantNext   bool
+	}{
+		"first_page": {
+			query:      "limit=2",
+			wantStatus: http.StatusOK,
+			wantLen:    2,
+			wantNext:   true,
+		},
+		"all": {
+			query:      "",
+			wantStatus: http.StatusOK,
+			wantLen:    3,
+		},
+		"search": {
+			query:      "search=B.LINK",
+			wantStatus: http.StatusOK,
+			wantLen:    1,
+		},
+		"nothing_found": {
+			query:      "created_after=2100-01-01T00:00:00Z",
+			wantStatus: http.StatusNoContent,
+		},
+		"envelope": {
+			query:      "limit=2&envelope=true",
+			envelope:   true,
+			wantStatus: http.StatusOK,
+			wantLen:    2,
+			wantNext:   true,
+		},
+		"envelope_nothing_found": {
+			query:      "created_after=2100-01-01T00:00:00Z&envelope=true",
+			envelope:   true,
+			wantStatus: http.StatusOK,
+		},
+		"invalid_envelope": {
+			query:      "envelope=yes",
+			wantStatus: http.StatusBadRequest,
+		},
+		"invalid_limit": {
+			query:      "limit=0",
+			wantStatus: http.StatusBadRequest,
+		},
+		"invalid_time": {
+			query:      "created_before=yesterday",
+			wantStatus: http.StatusBadRequest,
+		},
+		"invalid_cursor": {
+			query:      "cursor=%21",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls?"+tt.query, nil)
+			req = req.WithContext(session.ContextWithSession(req.Context(), &session.Session{UserID: "user"}))
+			w := httptest.NewRecorder()
+
+			o.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			require.Equal(t, tt.wantStatus, res.StatusCode)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var urls []SavedURL
+			next := res.Header.Get("X-Next-Cursor")
+			if tt.envelope {
+				var body struct {
+					URLs       []SavedURL `json:"urls"`
+					NextCursor string     `json:"next_cursor"`
+				}
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+				require.NotNil(t, body.URLs)
+				require.Equal(t, next, body.NextCursor)
+				urls = body.URLs
+			} else {
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&urls))
+			}
+			require.Len(t, urls, tt.wantLen)
+
+			if !tt.wantNext {
+				require.Empty(t, next)
+				require.Empty(t, res.Header.Get("Link"))
+				return
+			}
+
+			require.NotEmpty(t, next)
+			link := res.Header.Get("Link")
+			require.True(t, strings.HasSuffix(link, `>; rel="next"`))
+
+			linkURL, err := url.Parse(strings.TrimPrefix(strings.TrimSuffix(link, `>; rel="next"`), "<"))
+			require.NoError(t, err)
+			require.Equal(t, "http", linkURL.Scheme)
+			require.Equal(t, "base", linkURL.Host)
+			require.Equal(t, "/api/user/urls", linkURL.Path)
+			require.Equal(t, next, linkURL.Query().Get("cursor"))
+			require.Equal(t, "2", linkURL.Query().Get("limit"))
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package operation
 
-import "github.com/KonBal/url-shortener/internal/app/storage"
+import (
+	"fmt"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/storage"
+)
 
 // Returns random number.
 type Rand interface {
@@ -12,10 +17,60 @@ type Encoder interface {
 	Encode(v uint64) string
 }
 
+// Number of generated short urls tried before giving up on collisions.
+const maxCodeAttempts = 5
+
 // Service for managing urls.
 type ShortURLService struct {
 	BaseURL    string
 	Encoder    Encoder
 	Storage    storage.Storage
 	Uint64Rand Rand
+	Normalizer URLNormalizer
+	// Rejects urls which must not be shortened, everything is allowed if nil.
+	Policy interface {
+		Check(rawURL string) error
+	}
+}
+
+// checkURL normalizes url and checks it against the policy.
+func (s ShortURLService) checkURL(url string) (string, error) {
+	url, err := s.Normalizer.Normalize(url)
+	if err != nil {
+		return "", err
+	}
+
+	if s.Policy != nil {
+		if err := s.Policy.Check(url); err != nil {
+			return "", policyViolationError(fmt.Sprintf("url %s is rejected: %v", url, err))
+		}
+	}
+
+	return url, nil
+}
+
+// Optional parameters of a shortened url.
+type ShortenOptions struct {
+	// Used as the short url instead of a generated one if not empty.
+	Alias string
+	// The url stops working after ExpiresAt if it is set.
+	ExpiresAt *time.Time
+	// The url stops working after MaxClicks redirects if it is positive.
+	MaxClicks int64
+}
+
+func (o ShortenOptions) validate() error {
+	if o.ExpiresAt != nil && !o.ExpiresAt.After(time.Now()) {
+		return invalidLimitError(fmt.Sprintf("expiration time %s is in the past", o.ExpiresAt.Format(time.RFC3339)))
+	}
+
+	if o.MaxClicks < 0 {
+		return invalidLimitError(fmt.Sprintf("max clicks must not be negative, got %d", o.MaxClicks))
+	}
+
+	if o.Alias != "" {
+		return validateAlias(o.Alias)
+	}
+
+	return nil
 }
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/KonBal/url-shortener/internal/app/logger"
 	"github.com/KonBal/url-shortener/internal/app/session"
@@ -18,7 +19,7 @@ import (
 type Shorten struct {
 	Log     *logger.Logger
 	Service interface {
-		Shorten(ctx context.Context, userID string, url string) (string, error)
+		Shorten(ctx context.Context, userID string, url string, opts ShortenOptions) (string, error)
 	}
 }
 
@@ -36,13 +37,22 @@ func (o *Shorten) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	status := http.StatusCreated
 
-	short, err := o.Service.Shorten(ctx, s.UserID, string(body))
+	short, err := o.Service.Shorten(ctx, s.UserID, string(body), ShortenOptions{})
 	if err != nil {
 		var errUnique *notUniqueError
-		if errors.As(err, &errUnique) {
+		switch {
+		case errors.As(err, &errUnique):
 			status = http.StatusConflict
 			short = errUnique.ShortURL
-		} else {
+		case errors.Is(err, ErrInvalidURL):
+			o.Log.RequestError(req, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrPolicyViolation):
+			o.Log.RequestError(req, err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		default:
 			o.Log.RequestError(req, err)
 			http.Error(w, "An error has occured", http.StatusInternalServerError)
 			return
@@ -58,14 +68,17 @@ func (o *Shorten) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 type ShortenFromJSON struct {
 	Log     *logger.Logger
 	Service interface {
-		Shorten(ctx context.Context, userID string, url string) (string, error)
+		Shorten(ctx context.Context, userID string, url string, opts ShortenOptions) (string, error)
 	}
 }
 
 // ServeHTTP handles operation to shorten url recieved in JSON.
 func (o *ShortenFromJSON) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	var body struct {
-		URL string `json:"url"`
+		URL       string     `json:"url"`
+		Alias     string     `json:"alias,omitempty"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+		MaxClicks int64      `json:"max_clicks,omitempty"`
 	}
 
 	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
@@ -79,13 +92,28 @@ func (o *ShortenFromJSON) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	status := http.StatusCreated
 
-	short, err := o.Service.Shorten(ctx, s.UserID, body.URL)
+	opts := ShortenOptions{Alias: body.Alias, ExpiresAt: body.ExpiresAt, MaxClicks: body.MaxClicks}
+
+	short, err := o.Service.Shorten(ctx, s.UserID, body.URL, opts)
 	if err != nil {
 		var errUnique *notUniqueError
-		if errors.As(err, &errUnique) {
+		switch {
+		case errors.As(err, &errUnique):
 			status = http.StatusConflict
 			short = errUnique.ShortURL
-		} else {
+		case errors.Is(err, ErrInvalidURL), errors.Is(err, ErrInvalidAlias), errors.Is(err, ErrInvalidLimit):
+			o.Log.RequestError(req, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrAliasTaken):
+			o.Log.RequestError(req, err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, ErrPolicyViolation):
+			o.Log.RequestError(req, err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		default:
 			o.Log.RequestError(req, err)
 			http.Error(w, "An error has occured", http.StatusInternalServerError)
 			return
@@ -133,64 +161,150 @@ func (o *ShortenBatch) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Multi-Status tells the client to check each url when not all of them were created.
+	status := http.StatusCreated
+	for _, r := range res {
+		if r.Status != BatchStatusCreated {
+			status = http.StatusMultiStatus
+			break
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(res); err != nil {
 		o.Log.RequestError(req, fmt.Errorf("write response body: %w", err))
 	}
 }
 
 // Shorten computes a shortened URL for a given URL and saves both to the storage.
-func (s ShortURLService) Shorten(ctx context.Context, userID string, url string) (string, error) {
-	code := s.getEncoded()
+func (s ShortURLService) Shorten(ctx context.Context, userID string, url string, opts ShortenOptions) (string, error) {
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
 
-	err := s.Storage.Add(ctx, storage.URLEntry{ShortURL: code, OriginalURL: url}, userID)
-	switch {
-	case errors.Is(err, storage.ErrNotUnique):
-		sh, err := s.Storage.GetByOriginal(ctx, url)
-		if err != nil {
-			return "", err
+	url, err := s.checkURL(url)
+	if err != nil {
+		return "", err
+	}
+
+	alias := opts.Alias
+
+	for attempt := 1; ; attempt++ {
+		code := alias
+		if alias == "" {
+			code = s.getEncoded()
 		}
 
-		return "", &notUniqueError{ShortURL: resolveURL(s.BaseURL, sh.ShortURL)}
-	case err != nil:
-		return "", fmt.Errorf("shorten: failed to save url: %w", err)
-	}
+		err := s.Storage.Add(ctx, storage.URLEntry{
+			ShortURL:    code,
+			OriginalURL: url,
+			Alias:       alias != "",
+			ExpiresAt:   opts.ExpiresAt,
+			MaxClicks:   opts.MaxClicks,
+		}, userID)
+		switch {
+		case errors.Is(err, storage.ErrNotUnique):
+			sh, err := s.Storage.GetByOriginal(ctx, url)
+			if err != nil {
+				return "", err
+			}
+
+			return "", &notUniqueError{ShortURL: resolveURL(s.BaseURL, sh.ShortURL)}
+		case errors.Is(err, storage.ErrShortURLTaken) && alias != "":
+			return "", aliasTakenError(fmt.Sprintf("alias %s is already taken", alias))
+		case errors.Is(err, storage.ErrShortURLTaken) && attempt < maxCodeAttempts:
+			continue
+		case err != nil:
+			return "", fmt.Errorf("shorten: failed to save url: %w", err)
+		}
 
-	return resolveURL(s.BaseURL, code), nil
+		return resolveURL(s.BaseURL, code), nil
+	}
 }
 
 // Input type for original url.
 type CorrelatedOrigURL struct {
-	CorrelationID string `json:"correlation_id"`
-	OrigURL       string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	OrigURL       string     `json:"original_url"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	MaxClicks     int64      `json:"max_clicks,omitempty"`
 }
 
+// Status of a url in a batch.
+const (
+	BatchStatusCreated = "created"
+	BatchStatusExists  = "exists"
+	BatchStatusInvalid = "invalid"
+)
+
 // Result type for shortened url.
 type CorrelatedShortURL struct {
 	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
+	ShortURL      string `json:"short_url,omitempty"`
+	Status        string `json:"status"`
+	// Reason the url was not shortened.
+	Error string `json:"error,omitempty"`
 }
 
-// ShortenMany computes shortened URLs for given URLs and saves all to the storage.
+// ShortenMany computes shortened URLs for given URLs and saves new ones to the storage.
+// Every url gets its own result: created, already existing with its short url, or invalid.
 func (s ShortURLService) ShortenMany(ctx context.Context, userID string, orig []CorrelatedOrigURL) ([]CorrelatedShortURL, error) {
 	shorts := make([]CorrelatedShortURL, len(orig))
-	entries := make([]storage.URLEntry, len(orig))
+	var entries []storage.URLEntry
+	// Indexes of results the entries belong to.
+	var idx []int
 
 	for i, u := range orig {
-		code := s.getEncoded()
-		shorts[i] = CorrelatedShortURL{
-			CorrelationID: u.CorrelationID, ShortURL: resolveURL(s.BaseURL, code),
+		shorts[i].CorrelationID = u.CorrelationID
+
+		opts := ShortenOptions{ExpiresAt: u.ExpiresAt, MaxClicks: u.MaxClicks}
+		err := opts.validate()
+
+		var origURL string
+		if err == nil {
+			origURL, err = s.checkURL(u.OrigURL)
 		}
-		entries[i] = storage.URLEntry{ShortURL: code, OriginalURL: u.OrigURL}
+		if err != nil {
+			shorts[i].Status = BatchStatusInvalid
+			shorts[i].Error = err.Error()
+			continue
+		}
+
+		entries = append(entries, storage.URLEntry{
+			ShortURL: s.getEncoded(), OriginalURL: origURL, ExpiresAt: u.ExpiresAt, MaxClicks: u.MaxClicks,
+		})
+		idx = append(idx, i)
 	}
 
-	err := s.Storage.AddMany(ctx, entries, userID)
-	if err != nil {
-		return []CorrelatedShortURL{}, fmt.Errorf("shorten: failed to save urls: %w", err)
+	if len(entries) == 0 {
+		return shorts, nil
 	}
 
-	return shorts, nil
+	for attempt := 1; ; attempt++ {
+		res, err := s.Storage.AddOrGetMany(ctx, entries, userID)
+		switch {
+		case errors.Is(err, storage.ErrShortURLTaken) && attempt < maxCodeAttempts:
+			// Nothing is saved on collision, so the batch is retried with all codes regenerated.
+			for i := range entries {
+				entries[i].ShortURL = s.getEncoded()
+			}
+			continue
+		case err != nil:
+			return []CorrelatedShortURL{}, fmt.Errorf("shorten: failed to save urls: %w", err)
+		}
+
+		for k, r := range res {
+			sh := &shorts[idx[k]]
+			sh.ShortURL = resolveURL(s.BaseURL, r.ShortURL)
+			sh.Status = BatchStatusCreated
+			if r.Existed {
+				sh.Status = BatchStatusExists
+			}
+		}
+
+		return shorts, nil
+	}
 }
 
 func (s ShortURLService) getEncoded() string {
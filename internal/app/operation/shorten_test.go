@@ -2,6 +2,7 @@ package operation
 
 import (
 	"context"
+	"errors"
 	"strconv"
 	"testing"
 
@@ -21,6 +22,12 @@ func (r *prand) Next() uint64 {
 	return i
 }
 
+type policyFunc func(rawURL string) error
+
+func (f policyFunc) Check(rawURL string) error {
+	return f(rawURL)
+}
+
 type encoder struct{}
 
 func (en encoder) Encode(val uint64) string {
@@ -29,11 +36,14 @@ func (en encoder) Encode(val uint64) string {
 
 func TestShorten(t *testing.T) {
 	tests := map[string]struct {
-		orig string
-		base string
+		orig      string
+		base      string
+		alias     string
+		maxClicks int64
 
 		rand            Rand
 		encoder         Encoder
+		policy          policyFunc
 		existingEntries []storage.URLEntry
 
 		want        string
@@ -41,27 +51,65 @@ func TestShorten(t *testing.T) {
 		expectedErr string
 	}{
 		"empty": {
-			orig: "",
+			orig: " ",
 			base: "http://base",
 
 			rand:    &prand{0},
 			encoder: encoder{},
 
-			want: "http://base" + "/0",
+			wantErr:     true,
+			expectedErr: invalidURLError("url is empty").Error(),
+		},
+		"normalized": {
+			orig: " HTTP://Link.RU:80/Path?b=2&a=1#top\n",
+			base: "http://base",
+
+			rand:    &prand{12345},
+			encoder: encoder{},
+
+			want: "http://base" + "/12345",
+		},
+		"normalized_not_unique": {
+			orig: "http://LINK.ru:80",
+			base: "http://base",
+
+			rand:            &prand{12345},
+			encoder:         encoder{},
+			existingEntries: []storage.URLEntry{{ShortURL: "1", OriginalURL: "http://link.ru"}},
+
+			wantErr:     true,
+			expectedErr: notUniqueError{ShortURL: "http://base" + "/1"}.Error(),
+		},
+		"policy_violation": {
+			orig: "http://evil.com",
+			base: "http://base",
+			policy: func(rawURL string) error {
+				return errors.New("url matches blocklisted domain evil.com")
+			},
+
+			wantErr:     true,
+			expectedErr: policyViolationError("url http://evil.com is rejected: url matches blocklisted domain evil.com").Error(),
+		},
+		"javascript": {
+			orig: "javascript:alert(1)",
+			base: "http://base",
+
+			wantErr:     true,
+			expectedErr: invalidURLError(`url "javascript:alert(1)" has scheme "javascript", allowed schemes are http, https`).Error(),
 		},
 		"not_unique": {
-			orig: "link.ru",
+			orig: "http://link.ru",
 			base: "http://base",
 
 			rand:            &prand{12345},
 			encoder:         encoder{},
-			existingEntries: []storage.URLEntry{{OriginalURL: "link.ru"}},
+			existingEntries: []storage.URLEntry{{OriginalURL: "http://link.ru"}},
 
 			wantErr:     true,
 			expectedErr: notUniqueError{ShortURL: "http://base" + "/12345"}.Error(),
 		},
 		"correct": {
-			orig: "link.ru",
+			orig: "http://link.ru",
 			base: "http://base",
 
 			rand:    &prand{12345},
@@ -70,7 +118,7 @@ func TestShorten(t *testing.T) {
 			want: "http://base" + "/12345",
 		},
 		"correct_no_schema": {
-			orig: "link.ru",
+			orig: "http://link.ru",
 			base: "base",
 
 			rand:    &prand{12345},
@@ -78,6 +126,68 @@ func TestShorten(t *testing.T) {
 
 			want: "http://base" + "/12345",
 		},
+		"alias": {
+			orig:  "http://link.ru",
+			base:  "http://base",
+			alias: "spring-sale_2",
+
+			rand:    &prand{12345},
+			encoder: encoder{},
+
+			want: "http://base" + "/spring-sale_2",
+		},
+		"alias_forbidden_symbol": {
+			orig:  "http://link.ru",
+			base:  "http://base",
+			alias: "spring/sale",
+
+			wantErr:     true,
+			expectedErr: invalidAliasError("alias spring/sale contains forbidden symbol '/'").Error(),
+		},
+		"alias_reserved": {
+			orig:  "http://link.ru",
+			base:  "http://base",
+			alias: "API",
+
+			wantErr:     true,
+			expectedErr: invalidAliasError("alias API is reserved").Error(),
+		},
+		"negative_max_clicks": {
+			orig:      "http://link.ru",
+			base:      "http://base",
+			maxClicks: -1,
+
+			wantErr:     true,
+			expectedErr: invalidLimitError("max clicks must not be negative, got -1").Error(),
+		},
+		"alias_taken": {
+			orig:            "http://link.ru",
+			base:            "http://base",
+			alias:           "sale",
+			existingEntries: []storage.URLEntry{{ShortURL: "sale", OriginalURL: "other.ru", Alias: true}},
+
+			wantErr:     true,
+			expectedErr: aliasTakenError("alias sale is already taken").Error(),
+		},
+		"code_collision": {
+			orig:            "http://link.ru",
+			base:            "http://base",
+			rand:            &prand{12345, 12345, 678},
+			encoder:         encoder{},
+			existingEntries: []storage.URLEntry{{ShortURL: "12345", OriginalURL: "other.ru"}},
+
+			want: "http://base" + "/678",
+		},
+		"code_collisions_exhausted": {
+			orig:            "http://link.ru",
+			base:            "http://base",
+			rand:            &prand{},
+			encoder:         encoder{},
+			existingEntries: []storage.URLEntry{{ShortURL: "0", OriginalURL: "other.ru"}},
+
+			wantErr:     true,
+			expectedErr: "shorten: failed to save url: " + storage.ErrShortURLTaken.Error(),
+		},
 	}
 
 	for name, tt := range tests {
@@ -87,8 +197,11 @@ func TestShorten(t *testing.T) {
 			st.AddMany(ctx, tt.existingEntries, "")
 
 			s := ShortURLService{BaseURL: tt.base, Encoder: tt.encoder, Storage: st, Uint64Rand: tt.rand}
+			if tt.policy != nil {
+				s.Policy = tt.policy
+			}
 
-			got, err := s.Shorten(ctx, "", tt.orig)
+			got, err := s.Shorten(ctx, "", tt.orig, ShortenOptions{Alias: tt.alias, MaxClicks: tt.maxClicks})
 			if tt.wantErr {
 				require.EqualError(t, err, tt.expectedErr)
 				return
@@ -121,7 +234,49 @@ func TestShortenMany(t *testing.T) {
 			base:    "http://base",
 			rand:    &prand{0, 1},
 			encoder: encoder{},
-			want:    []CorrelatedShortURL{{CorrelationID: "1", ShortURL: "http://base" + "/0"}, {CorrelationID: "2", ShortURL: "http://base" + "/1"}},
+			want: []CorrelatedShortURL{
+				{CorrelationID: "1", ShortURL: "http://base" + "/0", Status: BatchStatusCreated},
+				{CorrelationID: "2", ShortURL: "http://base" + "/1", Status: BatchStatusCreated},
+			},
+		},
+		"per_item_results": {
+			orig: []CorrelatedOrigURL{
+				{CorrelationID: "1", OrigURL: "http://ab.cd"},
+				{CorrelationID: "2", OrigURL: "http://exists.ru"},
+				{CorrelationID: "3", OrigURL: "not a url"},
+				{CorrelationID: "4", OrigURL: "http://ef.gh", MaxClicks: -1},
+				{CorrelationID: "5", OrigURL: "http://ab.cd"},
+			},
+			base:            "http://base",
+			rand:            &prand{0, 1, 2},
+			encoder:         encoder{},
+			existingEntries: []storage.URLEntry{{ShortURL: "x", OriginalURL: "http://exists.ru"}},
+			want: []CorrelatedShortURL{
+				{CorrelationID: "1", ShortURL: "http://base" + "/0", Status: BatchStatusCreated},
+				{CorrelationID: "2", ShortURL: "http://base" + "/x", Status: BatchStatusExists},
+				{CorrelationID: "3", Status: BatchStatusInvalid, Error: `url "not a url" has scheme "", allowed schemes are http, https`},
+				{CorrelationID: "4", Status: BatchStatusInvalid, Error: "max clicks must not be negative, got -1"},
+				{CorrelationID: "5", ShortURL: "http://base" + "/0", Status: BatchStatusExists},
+			},
+		},
+		"code_collision": {
+			orig:            []CorrelatedOrigURL{{CorrelationID: "1", OrigURL: "http://ab.cd"}, {CorrelationID: "2", OrigURL: "http://ef.gh"}},
+			base:            "http://base",
+			rand:            &prand{7, 1, 2, 3},
+			encoder:         encoder{},
+			existingEntries: []storage.URLEntry{{ShortURL: "7", OriginalURL: "http://other.ru"}},
+			want: []CorrelatedShortURL{
+				{CorrelationID: "1", ShortURL: "http://base" + "/2", Status: BatchStatusCreated},
+				{CorrelationID: "2", ShortURL: "http://base" + "/3", Status: BatchStatusCreated},
+			},
+		},
+		"code_collisions_exhausted": {
+			orig:            []CorrelatedOrigURL{{CorrelationID: "1", OrigURL: "http://ab.cd"}},
+			base:            "http://base",
+			rand:            &prand{},
+			encoder:         encoder{},
+			existingEntries: []storage.URLEntry{{ShortURL: "0", OriginalURL: "http://other.ru"}},
+			wantErr:         true,
 		},
 	}
 
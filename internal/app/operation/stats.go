@@ -0,0 +1,243 @@
+package operation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+	"github.com/KonBal/url-shortener/internal/app/session"
+	"github.com/KonBal/url-shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// Number of referrers returned in stats.
+const topReferrersCount = 10
+
+// Represents operation to get click stats of a short url.
+type GetURLStats struct {
+	Log     *logger.Logger
+	Service interface {
+		GetURLStats(ctx context.Context, userID string, shortened string) (*URLStats, error)
+	}
+}
+
+// ServeHTTP handles operation to get click stats of a short url.
+func (o *GetURLStats) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	shortened := chi.URLParam(req, "short")
+	ctx := req.Context()
+	s := session.FromContext(ctx)
+
+	resp, err := o.Service.GetURLStats(ctx, s.UserID, shortened)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		o.Log.RequestError(req, err)
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrForbidden):
+		o.Log.RequestError(req, err)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	case err != nil:
+		o.Log.RequestError(req, err)
+		http.Error(w, "An error has occured", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		o.Log.RequestError(req, fmt.Errorf("write response body: %w", err))
+	}
+}
+
+// Click stats of a short url.
+type URLStats struct {
+	ShortURL     string           `json:"short_url"`
+	Total        int64            `json:"total"`
+	Daily        []DailyClicks    `json:"daily"`
+	TopReferrers []ReferrerClicks `json:"top_referrers"`
+}
+
+// Number of clicks during a day in UTC.
+type DailyClicks struct {
+	Date   string `json:"date"`
+	Clicks int64  `json:"clicks"`
+}
+
+// Number of clicks coming from a referrer.
+type ReferrerClicks struct {
+	Referrer string `json:"referrer"`
+	Clicks   int64  `json:"clicks"`
+}
+
+// GetURLStats returns click stats of the short url if it was added by the user.
+func (s ShortURLService) GetURLStats(ctx context.Context, userID string, shortened string) (*URLStats, error) {
+	u, err := s.Storage.GetByShort(ctx, shortened)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return nil, notFoundError(fmt.Sprintf("short url %s not found", shortened))
+	case err != nil:
+		return nil, fmt.Errorf("stats: failed to get url: %w", err)
+	}
+
+	if u.CreatedBy != userID {
+		return nil, forbiddenError(fmt.Sprintf("short url %s is not owned by user %s", shortened, userID))
+	}
+
+	stats, err := s.Storage.GetClickStats(ctx, shortened, topReferrersCount)
+	if err != nil {
+		return nil, fmt.Errorf("stats: failed to get click stats: %w", err)
+	}
+
+	res := &URLStats{
+		ShortURL:     resolveURL(s.BaseURL, shortened),
+		Total:        stats.Total,
+		Daily:        make([]DailyClicks, 0, len(stats.Daily)),
+		TopReferrers: make([]ReferrerClicks, 0, len(stats.TopReferrers)),
+	}
+
+	for _, d := range stats.Daily {
+		res.Daily = append(res.Daily, DailyClicks{Date: d.Day.Format(time.DateOnly), Clicks: d.Clicks})
+	}
+
+	for _, r := range stats.TopReferrers {
+		res.TopReferrers = append(res.TopReferrers, ReferrerClicks{Referrer: r.Referrer, Clicks: r.Clicks})
+	}
+
+	return res, nil
+}
+
+// Worker that recieves click events through a channel and saves them in batches.
+type ClickRecorder struct {
+	lifecycle
+
+	eventsCh   chan storage.ClickEvent
+	batchSize  int
+	workPeriod time.Duration
+	storage    storage.Storage
+	log        *logger.Logger
+	salt       []byte
+	// Number of events dropped since the last warning.
+	dropped atomic.Int64
+}
+
+// NewClickRecorder returns click recorder.
+// IP addresses are hashed with the salt, so hashes are comparable across restarts.
+// Without a salt a random one is used and hashes are comparable only within one run.
+func NewClickRecorder(s storage.Storage, log *logger.Logger, salt []byte,
+	bufSize, workPeriodSec int64) (*ClickRecorder, error) {
+	if len(salt) == 0 {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("click recorder: failed to generate salt: %w", err)
+		}
+	}
+
+	r := &ClickRecorder{
+		lifecycle:  newLifecycle(),
+		storage:    s,
+		eventsCh:   make(chan storage.ClickEvent, bufSize),
+		batchSize:  int(bufSize),
+		workPeriod: time.Duration(workPeriodSec) * time.Second,
+		log:        log,
+		salt:       salt,
+	}
+
+	go r.RunRecording()
+
+	return r, nil
+}
+
+// Record adds a click on the short url to the channel of events to be saved.
+// The event is dropped if the channel is full, so redirects never wait for storage.
+// Dropped events are counted and reported once per work period.
+func (r *ClickRecorder) Record(shortURL string, req *http.Request) {
+	e := storage.ClickEvent{
+		ShortURL:  shortURL,
+		Time:      time.Now().UTC(),
+		Referrer:  req.Referer(),
+		UserAgent: req.UserAgent(),
+		IPHash:    r.hashIP(req.RemoteAddr),
+	}
+
+	select {
+	case r.eventsCh <- e:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// reportDropped warns about events dropped since the last call.
+func (r *ClickRecorder) reportDropped() {
+	if n := r.dropped.Swap(0); n > 0 {
+		r.log.Warnf("%d click events are dropped: queue is full", n)
+	}
+}
+
+func (r *ClickRecorder) hashIP(addr string) string {
+	ip, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		ip = addr
+	}
+
+	h := sha256.New()
+	h.Write(r.salt)
+	h.Write([]byte(ip))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RunRecording runs a job to save click events when the batch is full or periodically.
+// When the recorder is shut down, the queued events are saved before it returns.
+func (r *ClickRecorder) RunRecording() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.workPeriod)
+	defer ticker.Stop()
+
+	var events []storage.ClickEvent
+
+	saveAndReset := func() {
+		if err := r.storage.AddClickEvents(r.ctx, events...); err != nil {
+			r.log.Errorf("failed to save %d click events: %v", len(events), err)
+		}
+
+		events = nil
+	}
+
+	for {
+		select {
+		case e := <-r.eventsCh:
+			events = append(events, e)
+			if len(events) >= r.batchSize {
+				saveAndReset()
+			}
+		case <-ticker.C:
+			r.reportDropped()
+
+			if len(events) == 0 {
+				continue
+			}
+
+			saveAndReset()
+		case <-r.stop:
+			for len(r.eventsCh) > 0 {
+				events = append(events, <-r.eventsCh)
+			}
+
+			if len(events) > 0 {
+				saveAndReset()
+			}
+			r.reportDropped()
+			return
+		}
+	}
+}
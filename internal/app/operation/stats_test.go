@@ -0,0 +1,177 @@
+package operation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+	"github.com/KonBal/url-shortener/internal/app/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestGetURLStats(t *testing.T) {
+	baseURL := "http://base"
+	day1 := time.Date(2023, 10, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2023, 10, 2, 23, 59, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		short  string
+		userID string
+
+		existingEntries []storage.URLEntry
+		owner           string
+		events          []storage.ClickEvent
+
+		want        *URLStats
+		wantErr     bool
+		expectedErr string
+	}{
+		"correct": {
+			short:           "abcd",
+			userID:          "user",
+			existingEntries: []storage.URLEntry{{ShortURL: "abcd", OriginalURL: "http://orig.link"}},
+			owner:           "user",
+			events: []storage.ClickEvent{
+				{ShortURL: "abcd", Time: day1, Referrer: "http://a"},
+				{ShortURL: "abcd", Time: day2, Referrer: "http://b"},
+				{ShortURL: "abcd", Time: day2, Referrer: "http://b"},
+				{ShortURL: "abcd", Time: day2},
+				{ShortURL: "other", Time: day2, Referrer: "http://a"},
+			},
+			want: &URLStats{
+				ShortURL: "http://base/abcd",
+				Total:    4,
+				Daily: []DailyClicks{
+					{Date: "2023-10-01", Clicks: 1},
+					{Date: "2023-10-02", Clicks: 3},
+				},
+				TopReferrers: []ReferrerClicks{
+					{Referrer: "http://b", Clicks: 2},
+					{Referrer: "http://a", Clicks: 1},
+				},
+			},
+		},
+		"no_clicks": {
+			short:           "abcd",
+			userID:          "user",
+			existingEntries: []storage.URLEntry{{ShortURL: "abcd", OriginalURL: "http://orig.link"}},
+			owner:           "user",
+			want: &URLStats{
+				ShortURL:     "http://base/abcd",
+				Daily:        []DailyClicks{},
+				TopReferrers: []ReferrerClicks{},
+			},
+		},
+		"not_owner": {
+			short:           "abcd",
+			userID:          "another",
+			existingEntries: []storage.URLEntry{{ShortURL: "abcd", OriginalURL: "http://orig.link"}},
+			owner:           "user",
+			wantErr:         true,
+			expectedErr:     forbiddenError("short url abcd is not owned by user another").Error(),
+		},
+		"not_found": {
+			short:       "abcd",
+			userID:      "user",
+			wantErr:     true,
+			expectedErr: notFoundError("short url abcd not found").Error(),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.TODO()
+			st := storage.NewInMemory()
+			st.AddMany(ctx, tt.existingEntries, tt.owner)
+			st.AddClickEvents(ctx, tt.events...)
+
+			s := ShortURLService{BaseURL: baseURL, Storage: st}
+
+			got, err := s.GetURLStats(ctx, tt.userID, tt.short)
+			if tt.wantErr {
+				require.EqualError(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestClickRecorderShutdown(t *testing.T) {
+	ctx := context.TODO()
+
+	st := storage.NewInMemory()
+	require.NoError(t, st.Add(ctx, storage.URLEntry{ShortURL: "a", OriginalURL: "http://a.link"}, "user"))
+
+	// The period is long enough for nothing to be saved before shutdown.
+	r, err := NewClickRecorder(st, logger.NewLogger(zap.NewNop()), nil, 16, 3600)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		r.Record("a", httptest.NewRequest(http.MethodGet, "/a", nil))
+	}
+	require.NoError(t, r.Shutdown(ctx))
+
+	stats, err := st.GetClickStats(ctx, "a", 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), stats.Total)
+}
+
+func TestClickRecorderIPHashSalt(t *testing.T) {
+	log := logger.NewLogger(zap.NewNop())
+	newRecorder := func(salt []byte) *ClickRecorder {
+		r, err := NewClickRecorder(storage.NewInMemory(), log, salt, 16, 3600)
+		require.NoError(t, err)
+		t.Cleanup(func() { r.Shutdown(context.TODO()) })
+		return r
+	}
+
+	const addr = "192.0.2.1:1234"
+
+	// Hashes with a configured salt survive restarts.
+	first, second := newRecorder([]byte("salt")), newRecorder([]byte("salt"))
+	require.Equal(t, first.hashIP(addr), second.hashIP(addr))
+	require.NotEqual(t, first.hashIP(addr), first.hashIP("192.0.2.2:1234"))
+
+	require.NotEqual(t, newRecorder(nil).hashIP(addr), newRecorder(nil).hashIP(addr))
+}
+
+func TestClickRecorderDropped(t *testing.T) {
+	ctx := context.TODO()
+	core, logs := observer.New(zap.WarnLevel)
+
+	st := storage.NewInMemory()
+	r := &ClickRecorder{
+		lifecycle:  newLifecycle(),
+		eventsCh:   make(chan storage.ClickEvent, 1),
+		batchSize:  1,
+		workPeriod: time.Hour,
+		storage:    st,
+		log:        logger.NewLogger(zap.New(core)),
+		salt:       []byte("salt"),
+	}
+
+	// Nothing reads the queue yet, so the events after the first one are dropped.
+	for i := 0; i < 3; i++ {
+		r.Record("a", httptest.NewRequest(http.MethodGet, "/a", nil))
+	}
+	require.Zero(t, logs.Len())
+
+	go r.RunRecording()
+	require.NoError(t, r.Shutdown(ctx))
+
+	require.Equal(t, 1, logs.Len())
+	require.Contains(t, logs.All()[0].Message, "2 click events are dropped")
+
+	stats, err := st.GetClickStats(ctx, "a", 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Total)
+}
@@ -0,0 +1,109 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+	"github.com/KonBal/url-shortener/internal/app/session"
+	"github.com/KonBal/url-shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// Represents operation to change original url of a short url.
+type UpdateURL struct {
+	Log     *logger.Logger
+	Service interface {
+		UpdateURL(ctx context.Context, userID string, shortened string, origURL string) (*SavedURL, error)
+	}
+}
+
+// ServeHTTP handles operation to change original url of a short url.
+func (o *UpdateURL) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		OriginalURL string `json:"original_url"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		o.Log.RequestError(req, fmt.Errorf("read request body: %w", err))
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	shortened := chi.URLParam(req, "short")
+	ctx := req.Context()
+	s := session.FromContext(ctx)
+
+	resp, err := o.Service.UpdateURL(ctx, s.UserID, shortened, body.OriginalURL)
+	if err != nil {
+		o.Log.RequestError(req, err)
+
+		var errUnique *notUniqueError
+		switch {
+		case errors.Is(err, ErrInvalidURL):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrPolicyViolation):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case errors.Is(err, ErrNotFound):
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		case errors.Is(err, ErrDeleted):
+			http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+		case errors.Is(err, ErrForbidden):
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		case errors.As(err, &errUnique):
+			http.Error(w, fmt.Sprintf("url is already shortened as %s", errUnique.ShortURL), http.StatusConflict)
+		default:
+			http.Error(w, "An error has occured", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		o.Log.RequestError(req, fmt.Errorf("write response body: %w", err))
+	}
+}
+
+// UpdateURL changes original url of the short url if it was added by the user.
+func (s ShortURLService) UpdateURL(ctx context.Context, userID string, shortened string, origURL string) (*SavedURL, error) {
+	origURL, err := s.checkURL(origURL)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := s.Storage.GetByShort(ctx, shortened)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return nil, notFoundError(fmt.Sprintf("short url %s not found", shortened))
+	case err != nil:
+		return nil, fmt.Errorf("update: failed to get url: %w", err)
+	}
+
+	if u.CreatedBy != userID {
+		return nil, forbiddenError(fmt.Sprintf("short url %s is not owned by user %s", shortened, userID))
+	}
+	if u.Deleted {
+		return nil, deletedError(fmt.Sprintf("short url %s is deleted", shortened))
+	}
+
+	err = s.Storage.UpdateOriginal(ctx, shortened, userID, origURL)
+	switch {
+	case errors.Is(err, storage.ErrNotUnique):
+		sh, err := s.Storage.GetByOriginal(ctx, origURL)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, &notUniqueError{ShortURL: resolveURL(s.BaseURL, sh.ShortURL)}
+	case errors.Is(err, storage.ErrNotFound):
+		// The url was deleted after it had been read.
+		return nil, deletedError(fmt.Sprintf("short url %s is deleted", shortened))
+	case err != nil:
+		return nil, fmt.Errorf("update: failed to save url: %w", err)
+	}
+
+	return &SavedURL{ShortURL: resolveURL(s.BaseURL, shortened), OriginalURL: origURL}, nil
+}
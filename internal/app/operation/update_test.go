@@ -0,0 +1,98 @@
+package operation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/KonBal/url-shortener/internal/app/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateURL(t *testing.T) {
+	existing := []storage.URLEntry{
+		{ShortURL: "abcd", OriginalURL: "http://orig.link"},
+		{ShortURL: "efgh", OriginalURL: "http://taken.link"},
+		{ShortURL: "gone", OriginalURL: "http://gone.link", Deleted: true},
+	}
+
+	tests := map[string]struct {
+		short   string
+		userID  string
+		origURL string
+
+		want        *SavedURL
+		wantErr     bool
+		expectedErr string
+	}{
+		"correct": {
+			short:   "abcd",
+			userID:  "user",
+			origURL: "HTTP://New.Link:80/",
+			want:    &SavedURL{ShortURL: "http://base/abcd", OriginalURL: "http://new.link/"},
+		},
+		"same_url": {
+			short:   "abcd",
+			userID:  "user",
+			origURL: "http://orig.link",
+			want:    &SavedURL{ShortURL: "http://base/abcd", OriginalURL: "http://orig.link"},
+		},
+		"invalid_url": {
+			short:       "abcd",
+			userID:      "user",
+			origURL:     "orig.link",
+			wantErr:     true,
+			expectedErr: invalidURLError(`url "orig.link" has scheme "", allowed schemes are http, https`).Error(),
+		},
+		"not_unique": {
+			short:       "abcd",
+			userID:      "user",
+			origURL:     "http://taken.link",
+			wantErr:     true,
+			expectedErr: notUniqueError{ShortURL: "http://base/efgh"}.Error(),
+		},
+		"not_owner": {
+			short:       "abcd",
+			userID:      "another",
+			origURL:     "http://new.link",
+			wantErr:     true,
+			expectedErr: forbiddenError("short url abcd is not owned by user another").Error(),
+		},
+		"deleted": {
+			short:       "gone",
+			userID:      "user",
+			origURL:     "http://new.link",
+			wantErr:     true,
+			expectedErr: deletedError("short url gone is deleted").Error(),
+		},
+		"not_found": {
+			short:       "missing",
+			userID:      "user",
+			origURL:     "http://new.link",
+			wantErr:     true,
+			expectedErr: notFoundError("short url missing not found").Error(),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.TODO()
+			st := storage.NewInMemory()
+			require.NoError(t, st.AddMany(ctx, existing, "user"))
+
+			s := ShortURLService{BaseURL: "http://base", Storage: st}
+
+			got, err := s.UpdateURL(ctx, tt.userID, tt.short, tt.origURL)
+			if tt.wantErr {
+				require.EqualError(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+
+			u, err := st.GetByShort(ctx, tt.short)
+			require.NoError(t, err)
+			require.Equal(t, tt.want.OriginalURL, u.OriginalURL)
+		})
+	}
+}
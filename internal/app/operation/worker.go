@@ -0,0 +1,46 @@
+package operation
+
+import (
+	"context"
+	"sync"
+)
+
+// lifecycle stops a background job started by a worker constructor.
+// The job must close done when it returns and use ctx for storage calls.
+type lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   chan struct{}
+	done   chan struct{}
+	// Pointer, so that lifecycle can be returned by value.
+	stopOnce *sync.Once
+}
+
+func newLifecycle() lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return lifecycle{
+		ctx:      ctx,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		stopOnce: &sync.Once{},
+	}
+}
+
+// Shutdown stops the job and waits until it finishes its pending work.
+// If ctx expires first, the pending work is cancelled.
+// Calls after the first one wait for the job to finish too.
+func (l *lifecycle) Shutdown(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	defer l.cancel()
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		<-l.done
+		return ctx.Err()
+	}
+}
@@ -3,4 +3,6 @@ package session
 // Session.
 type Session struct {
 	UserID string
+	// The user is created for this request, the client had no valid auth cookie.
+	New bool
 }
@@ -0,0 +1,579 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// short url -> entry
+	urlsBucket = []byte("urls")
+	// original url -> short url
+	originalsBucket = []byte("originals")
+	// user id -> nested bucket with short urls as keys
+	usersBucket = []byte("users")
+	// short url -> nested bucket with click events
+	clicksBucket = []byte("clicks")
+)
+
+// Bolt does not allow empty bucket names, so urls without a user are kept under
+// a name that generated user ids never take.
+var noUserBucket = []byte{0}
+
+// userBucketName returns the name of the nested bucket of the user in usersBucket.
+func userBucketName(userID string) []byte {
+	if userID == "" {
+		return noUserBucket
+	}
+	return []byte(userID)
+}
+
+type boltEntry struct {
+	OriginalURL string     `json:"original_url"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Deleted     bool       `json:"deleted,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	Alias       bool       `json:"alias,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	MaxClicks   int64      `json:"max_clicks,omitempty"`
+	Clicks      int64      `json:"clicks,omitempty"`
+}
+
+func (e *boltEntry) toURLEntry(shortURL string) *URLEntry {
+	return &URLEntry{
+		ShortURL:    shortURL,
+		OriginalURL: e.OriginalURL,
+		Deleted:     e.Deleted,
+		DeletedAt:   e.DeletedAt,
+		Alias:       e.Alias,
+		CreatedBy:   e.CreatedBy,
+		CreatedAt:   e.CreatedAt,
+		ExpiresAt:   e.ExpiresAt,
+		MaxClicks:   e.MaxClicks,
+		Clicks:      e.Clicks,
+	}
+}
+
+// Storage in an embedded key-value database.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltStorage opens or creates the database file and its buckets.
+func NewBoltStorage(fname string) (*BoltStorage, error) {
+	db, err := bolt.Open(fname, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("bolt: failed to open %s: %w", fname, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{urlsBucket, originalsBucket, usersBucket, clicksBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("bolt: failed to create buckets: %w", err)
+	}
+
+	return &BoltStorage{db: db}, nil
+}
+
+// Close closes the database.
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+// Add saves entry.
+func (s *BoltStorage) Add(ctx context.Context, u URLEntry, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		return putEntry(tx, u, userID)
+	})
+}
+
+// AddMany saves several entries in one transaction.
+func (s *BoltStorage) AddMany(ctx context.Context, urls []URLEntry, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		for _, u := range urls {
+			if err := putEntry(tx, u, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddOrGetMany adds entries with new original urls and finds existing ones for the rest
+// in one transaction. Nothing is added if a short url is taken.
+func (s *BoltStorage) AddOrGetMany(ctx context.Context, urls []URLEntry, userID string) ([]AddResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]AddResult, len(urls))
+
+	err := s.update(func(tx *bolt.Tx) error {
+		originals := tx.Bucket(originalsBucket)
+
+		for i, u := range urls {
+			if short := originals.Get([]byte(u.OriginalURL)); short != nil {
+				results[i] = AddResult{ShortURL: string(short), Existed: true}
+				continue
+			}
+
+			if err := putEntry(tx, u, userID); err != nil {
+				return err
+			}
+			results[i] = AddResult{ShortURL: u.ShortURL}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func putEntry(tx *bolt.Tx, u URLEntry, userID string) error {
+	urls := tx.Bucket(urlsBucket)
+	originals := tx.Bucket(originalsBucket)
+
+	if originals.Get([]byte(u.OriginalURL)) != nil {
+		return ErrNotUnique
+	}
+	if urls.Get([]byte(u.ShortURL)) != nil {
+		return ErrShortURLTaken
+	}
+
+	data, err := json.Marshal(boltEntry{
+		OriginalURL: u.OriginalURL,
+		CreatedBy:   userID,
+		CreatedAt:   creationTime(),
+		Deleted:     u.Deleted,
+		DeletedAt:   u.DeletedAt,
+		Alias:       u.Alias,
+		ExpiresAt:   u.ExpiresAt,
+		MaxClicks:   u.MaxClicks,
+		Clicks:      u.Clicks,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := urls.Put([]byte(u.ShortURL), data); err != nil {
+		return err
+	}
+	if err := originals.Put([]byte(u.OriginalURL), []byte(u.ShortURL)); err != nil {
+		return err
+	}
+
+	user, err := tx.Bucket(usersBucket).CreateBucketIfNotExists(userBucketName(userID))
+	if err != nil {
+		return err
+	}
+
+	return user.Put([]byte(u.ShortURL), nil)
+}
+
+// GetByShort retrieves entry by short url.
+func (s *BoltStorage) GetByShort(ctx context.Context, shortURL string) (*URLEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var u *URLEntry
+
+	err := s.view(func(tx *bolt.Tx) error {
+		e, err := getEntry(tx, shortURL)
+		if err != nil {
+			return err
+		}
+
+		u = e.toURLEntry(shortURL)
+		return nil
+	})
+
+	return u, err
+}
+
+// GetByOriginal retrieves entry by original url.
+func (s *BoltStorage) GetByOriginal(ctx context.Context, origURL string) (*URLEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var u *URLEntry
+
+	err := s.view(func(tx *bolt.Tx) error {
+		short := tx.Bucket(originalsBucket).Get([]byte(origURL))
+		if short == nil {
+			return ErrNotFound
+		}
+
+		e, err := getEntry(tx, string(short))
+		if err != nil {
+			return err
+		}
+
+		u = e.toURLEntry(string(short))
+		return nil
+	})
+
+	return u, err
+}
+
+// GetURLsCreatedBy retrieves entries added by user matching the filter.
+func (s *BoltStorage) GetURLsCreatedBy(ctx context.Context, userID string, filter URLFilter) ([]URLEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var urls []URLEntry
+
+	err := s.view(func(tx *bolt.Tx) error {
+		user := tx.Bucket(usersBucket).Bucket(userBucketName(userID))
+		if user == nil {
+			return nil
+		}
+
+		return user.ForEach(func(k, _ []byte) error {
+			e, err := getEntry(tx, string(k))
+			if err != nil {
+				return err
+			}
+
+			urls = append(urls, *e.toURLEntry(string(k)))
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return applyURLFilter(urls, filter), nil
+}
+
+func getEntry(tx *bolt.Tx, shortURL string) (*boltEntry, error) {
+	data := tx.Bucket(urlsBucket).Get([]byte(shortURL))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	var e boltEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+func updateEntry(tx *bolt.Tx, shortURL string, f func(e *boltEntry) error) error {
+	e, err := getEntry(tx, shortURL)
+	if err != nil {
+		return err
+	}
+
+	if err := f(e); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(urlsBucket).Put([]byte(shortURL), data)
+}
+
+// MarkDeleted sets deleted flag to the entries created by the given users and returns them.
+func (s *BoltStorage) MarkDeleted(ctx context.Context, urls ...EntryToDelete) ([]EntryToDelete, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	return s.updateOwned(urls, func(e *boltEntry) {
+		if !e.Deleted {
+			e.Deleted = true
+			e.DeletedAt = &now
+		}
+	})
+}
+
+// RestoreDeleted clears deleted flag of the entries created by the given users.
+func (s *BoltStorage) RestoreDeleted(ctx context.Context, urls ...EntryToDelete) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, err := s.updateOwned(urls, func(e *boltEntry) {
+		e.Deleted = false
+		e.DeletedAt = nil
+	})
+	return err
+}
+
+// updateOwned applies f to the entries created by the given users, skipping missing ones.
+// It returns the urls of the updated entries.
+func (s *BoltStorage) updateOwned(urls []EntryToDelete, f func(e *boltEntry)) ([]EntryToDelete, error) {
+	var owned []EntryToDelete
+
+	err := s.update(func(tx *bolt.Tx) error {
+		for _, u := range urls {
+			err := updateEntry(tx, u.ShortURL, func(e *boltEntry) error {
+				if e.CreatedBy == u.UserID {
+					f(e)
+					owned = append(owned, u)
+				}
+				return nil
+			})
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return owned, nil
+}
+
+// UpdateOriginal changes original url of the entry if it is created by the user and not deleted.
+func (s *BoltStorage) UpdateOriginal(ctx context.Context, shortURL string, userID string, origURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		originals := tx.Bucket(originalsBucket)
+
+		return updateEntry(tx, shortURL, func(e *boltEntry) error {
+			if e.CreatedBy != userID || e.Deleted {
+				return ErrNotFound
+			}
+
+			if short := originals.Get([]byte(origURL)); short != nil {
+				if string(short) == shortURL {
+					return nil
+				}
+				return ErrNotUnique
+			}
+
+			if err := originals.Delete([]byte(e.OriginalURL)); err != nil {
+				return err
+			}
+			if err := originals.Put([]byte(origURL), []byte(shortURL)); err != nil {
+				return err
+			}
+
+			e.OriginalURL = origURL
+			return nil
+		})
+	})
+}
+
+// PurgeDeleted removes at most limit entries deleted before deletedBefore with their click events.
+// It returns short urls of the removed entries.
+func (s *BoltStorage) PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var purged []string
+
+	err := s.update(func(tx *bolt.Tx) error {
+		var toPurge []string
+		var entries []*boltEntry
+
+		c := tx.Bucket(urlsBucket).Cursor()
+		for k, v := c.First(); k != nil && len(toPurge) < limit; k, v = c.Next() {
+			var e boltEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+
+			if e.Deleted && e.DeletedAt != nil && e.DeletedAt.Before(deletedBefore) {
+				toPurge = append(toPurge, string(k))
+				entries = append(entries, &e)
+			}
+		}
+
+		for i, short := range toPurge {
+			e := entries[i]
+
+			if err := tx.Bucket(urlsBucket).Delete([]byte(short)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(originalsBucket).Delete([]byte(e.OriginalURL)); err != nil {
+				return err
+			}
+			if user := tx.Bucket(usersBucket).Bucket(userBucketName(e.CreatedBy)); user != nil {
+				if err := user.Delete([]byte(short)); err != nil {
+					return err
+				}
+			}
+
+			err := tx.Bucket(clicksBucket).DeleteBucket([]byte(short))
+			if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+
+		purged = toPurge
+		return nil
+	})
+
+	return purged, err
+}
+
+// CountClick increases the click counter of the entry unless its click limit is reached.
+func (s *BoltStorage) CountClick(ctx context.Context, shortURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		return updateEntry(tx, shortURL, func(e *boltEntry) error {
+			if e.MaxClicks > 0 && e.Clicks >= e.MaxClicks {
+				return ErrClickLimitReached
+			}
+
+			e.Clicks++
+			return nil
+		})
+	})
+}
+
+type boltClickEvent struct {
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPHash    string    `json:"ip_hash,omitempty"`
+}
+
+// AddClickEvents saves click events.
+func (s *BoltStorage) AddClickEvents(ctx context.Context, events ...ClickEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		for _, e := range events {
+			clicks, err := tx.Bucket(clicksBucket).CreateBucketIfNotExists([]byte(e.ShortURL))
+			if err != nil {
+				return err
+			}
+
+			data, err := json.Marshal(boltClickEvent{
+				Time:      e.Time,
+				Referrer:  e.Referrer,
+				UserAgent: e.UserAgent,
+				IPHash:    e.IPHash,
+			})
+			if err != nil {
+				return err
+			}
+
+			seq, err := clicks.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+
+			if err := clicks.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetClickStats returns aggregated click events of the short url.
+func (s *BoltStorage) GetClickStats(ctx context.Context, shortURL string, topReferrers int) (*ClickStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []ClickEvent
+
+	err := s.view(func(tx *bolt.Tx) error {
+		clicks := tx.Bucket(clicksBucket).Bucket([]byte(shortURL))
+		if clicks == nil {
+			return nil
+		}
+
+		return clicks.ForEach(func(_, v []byte) error {
+			var e boltClickEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+
+			events = append(events, ClickEvent{
+				ShortURL:  shortURL,
+				Time:      e.Time,
+				Referrer:  e.Referrer,
+				UserAgent: e.UserAgent,
+				IPHash:    e.IPHash,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregateClicks(events, topReferrers), nil
+}
+
+// Ping returns nil unless the context is done.
+func (s *BoltStorage) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// view runs f in a read-only transaction wrapping unexpected errors.
+func (s *BoltStorage) view(f func(tx *bolt.Tx) error) error {
+	return wrapBoltError(s.db.View(f))
+}
+
+// update runs f in a read-write transaction wrapping unexpected errors.
+func (s *BoltStorage) update(f func(tx *bolt.Tx) error) error {
+	return wrapBoltError(s.db.Update(f))
+}
+
+func wrapBoltError(err error) error {
+	switch {
+	case err == nil,
+		errors.Is(err, ErrNotFound),
+		errors.Is(err, ErrNotUnique),
+		errors.Is(err, ErrShortURLTaken),
+		errors.Is(err, ErrClickLimitReached):
+		return err
+	default:
+		return fmt.Errorf("bolt: %w", err)
+	}
+}
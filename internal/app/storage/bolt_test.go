@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltStorageReopen(t *testing.T) {
+	ctx := context.TODO()
+	fname := filepath.Join(t.TempDir(), "urls.db")
+
+	s, err := NewBoltStorage(fname)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru", MaxClicks: 5}, "user"))
+	require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "b", OriginalURL: "http://b.ru"}, ""))
+	require.NoError(t, s.CountClick(ctx, "a"))
+	require.NoError(t, s.AddClickEvents(ctx, ClickEvent{ShortURL: "a", Time: time.Now().UTC(), Referrer: "http://ref.ru"}))
+	_, err = s.MarkDeleted(ctx, EntryToDelete{ShortURL: "b", UserID: ""})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = NewBoltStorage(fname)
+	require.NoError(t, err)
+	defer s.Close()
+
+	a, err := s.GetByOriginal(ctx, "http://a.ru")
+	require.NoError(t, err)
+	require.Equal(t, "a", a.ShortURL)
+	require.Equal(t, int64(1), a.Clicks)
+	require.Equal(t, int64(5), a.MaxClicks)
+
+	b, err := s.GetByShort(ctx, "b")
+	require.NoError(t, err)
+	require.True(t, b.Deleted)
+
+	urls, err := s.GetURLsCreatedBy(ctx, "", URLFilter{IncludeDeleted: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, shortURLs(urls))
+
+	stats, err := s.GetClickStats(ctx, "a", 5)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Total)
+	require.Equal(t, []ReferrerClicks{{Referrer: "http://ref.ru", Clicks: 1}}, stats.TopReferrers)
+}
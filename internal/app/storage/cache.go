@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Storage decorator that caches results of GetByShort in a bounded LRU with TTL.
+// ErrNotFound results are cached too. Entries are invalidated on writes through the decorator,
+// writes made through other instances sharing the storage are seen only after the TTL.
+type CachedStorage struct {
+	Storage
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	size  int
+	ttl   time.Duration
+
+	// Lookups of the underlying storage in progress by short url.
+	fetches map[string]*cacheFetch
+	// Number of lookups started, identifies a lookup.
+	seq uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	now func() time.Time
+}
+
+// Lookups in progress for a short url.
+type cacheFetch struct {
+	inFlight int
+	// Lookups started before the last invalidation of the short url, i.e. not later than it, are not cached.
+	invalidated uint64
+}
+
+type cacheItem struct {
+	shortURL  string
+	entry     *URLEntry
+	expiresAt time.Time
+}
+
+// Counters of cache lookups.
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Size   int   `json:"size"`
+}
+
+// NewCachedStorage wraps s with a cache of at most size entries kept for ttl.
+func NewCachedStorage(s Storage, size int, ttl time.Duration) *CachedStorage {
+	return &CachedStorage{
+		Storage: s,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element, size),
+		fetches: make(map[string]*cacheFetch),
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+// GetByShort retrieves entry by short url from cache or from the underlying storage.
+func (s *CachedStorage) GetByShort(ctx context.Context, shortURL string) (*URLEntry, error) {
+	entry, ok, fetch := s.get(shortURL)
+	if ok {
+		s.hits.Add(1)
+		if entry == nil {
+			return nil, ErrNotFound
+		}
+		return entry, nil
+	}
+
+	s.misses.Add(1)
+
+	entry, err := s.Storage.GetByShort(ctx, shortURL)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		s.put(shortURL, nil, fetch)
+	case err == nil:
+		s.put(shortURL, entry, fetch)
+	default:
+		s.mu.Lock()
+		s.finishFetch(shortURL, fetch)
+		s.mu.Unlock()
+	}
+
+	return entry, err
+}
+
+// Add saves entry and drops cached negative result for it.
+func (s *CachedStorage) Add(ctx context.Context, u URLEntry, userID string) error {
+	err := s.Storage.Add(ctx, u, userID)
+	s.invalidate(u.ShortURL)
+
+	return err
+}
+
+// AddMany saves entries and drops cached negative results for them.
+func (s *CachedStorage) AddMany(ctx context.Context, urls []URLEntry, userID string) error {
+	err := s.Storage.AddMany(ctx, urls, userID)
+	for _, u := range urls {
+		s.invalidate(u.ShortURL)
+	}
+
+	return err
+}
+
+// AddOrGetMany saves new entries and drops cached negative results for them.
+func (s *CachedStorage) AddOrGetMany(ctx context.Context, urls []URLEntry, userID string) ([]AddResult, error) {
+	results, err := s.Storage.AddOrGetMany(ctx, urls, userID)
+	for _, u := range urls {
+		s.invalidate(u.ShortURL)
+	}
+
+	return results, err
+}
+
+// MarkDeleted sets deleted flag and drops cached entries.
+func (s *CachedStorage) MarkDeleted(ctx context.Context, urls ...EntryToDelete) ([]EntryToDelete, error) {
+	owned, err := s.Storage.MarkDeleted(ctx, urls...)
+	for _, u := range urls {
+		s.invalidate(u.ShortURL)
+	}
+
+	return owned, err
+}
+
+// RestoreDeleted clears deleted flag and drops cached entries.
+func (s *CachedStorage) RestoreDeleted(ctx context.Context, urls ...EntryToDelete) error {
+	err := s.Storage.RestoreDeleted(ctx, urls...)
+	for _, u := range urls {
+		s.invalidate(u.ShortURL)
+	}
+
+	return err
+}
+
+// PurgeDeleted removes deleted entries and drops them from cache.
+func (s *CachedStorage) PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) ([]string, error) {
+	purged, err := s.Storage.PurgeDeleted(ctx, deletedBefore, limit)
+	for _, short := range purged {
+		s.invalidate(short)
+	}
+
+	return purged, err
+}
+
+// UpdateOriginal changes original url and drops the cached entry.
+func (s *CachedStorage) UpdateOriginal(ctx context.Context, shortURL string, userID string, origURL string) error {
+	err := s.Storage.UpdateOriginal(ctx, shortURL, userID, origURL)
+	s.invalidate(shortURL)
+
+	return err
+}
+
+// CountClick increases the click counter and drops the cached entry.
+func (s *CachedStorage) CountClick(ctx context.Context, shortURL string) error {
+	err := s.Storage.CountClick(ctx, shortURL)
+	s.invalidate(shortURL)
+
+	return err
+}
+
+// Stats returns counters of cache lookups.
+func (s *CachedStorage) Stats() CacheStats {
+	s.mu.Lock()
+	size := s.ll.Len()
+	s.mu.Unlock()
+
+	return CacheStats{Hits: s.hits.Load(), Misses: s.misses.Load(), Size: size}
+}
+
+// get returns cached entry and whether it was found.
+// On a miss it starts a lookup and returns its number to pass to put.
+func (s *CachedStorage) get(shortURL string) (*URLEntry, bool, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[shortURL]
+	if ok {
+		item := el.Value.(*cacheItem)
+		if s.now().Before(item.expiresAt) {
+			s.ll.MoveToFront(el)
+
+			if item.entry == nil {
+				return nil, true, 0
+			}
+
+			entry := *item.entry
+			return &entry, true, 0
+		}
+
+		s.remove(el)
+	}
+
+	s.seq++
+
+	f, ok := s.fetches[shortURL]
+	if !ok {
+		f = &cacheFetch{}
+		s.fetches[shortURL] = f
+	}
+	f.inFlight++
+
+	return nil, false, s.seq
+}
+
+// finishFetch ends the lookup and reports whether its result may be cached.
+// Must be called with the lock held.
+func (s *CachedStorage) finishFetch(shortURL string, fetch uint64) bool {
+	f, ok := s.fetches[shortURL]
+	if !ok {
+		return false
+	}
+
+	f.inFlight--
+	if f.inFlight == 0 {
+		delete(s.fetches, shortURL)
+	}
+
+	return fetch > f.invalidated
+}
+
+func (s *CachedStorage) put(shortURL string, entry *URLEntry, fetch uint64) {
+	if entry != nil {
+		copied := *entry
+		entry = &copied
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.finishFetch(shortURL, fetch) {
+		return
+	}
+
+	item := &cacheItem{shortURL: shortURL, entry: entry, expiresAt: s.now().Add(s.ttl)}
+
+	if el, ok := s.items[shortURL]; ok {
+		el.Value = item
+		s.ll.MoveToFront(el)
+		return
+	}
+
+	s.items[shortURL] = s.ll.PushFront(item)
+
+	for s.ll.Len() > s.size {
+		s.remove(s.ll.Back())
+	}
+}
+
+// invalidate drops the cached entry, results of lookups in progress for it are not cached.
+func (s *CachedStorage) invalidate(shortURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, ok := s.fetches[shortURL]; ok {
+		f.invalidated = s.seq
+	}
+
+	if el, ok := s.items[shortURL]; ok {
+		s.remove(el)
+	}
+}
+
+func (s *CachedStorage) remove(el *list.Element) {
+	s.ll.Remove(el)
+	delete(s.items, el.Value.(*cacheItem).shortURL)
+}
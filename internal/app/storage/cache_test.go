@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Counts lookups that reach the underlying storage.
+type countingStorage struct {
+	Storage
+	gets int
+}
+
+func (s *countingStorage) GetByShort(ctx context.Context, shortURL string) (*URLEntry, error) {
+	s.gets++
+	return s.Storage.GetByShort(ctx, shortURL)
+}
+
+// Calls the hook in the middle of lookups.
+type hookStorage struct {
+	Storage
+	onGet func(shortURL string)
+}
+
+func (s *hookStorage) GetByShort(ctx context.Context, shortURL string) (*URLEntry, error) {
+	s.onGet(shortURL)
+	return s.Storage.GetByShort(ctx, shortURL)
+}
+
+func TestCachedStorage(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("hit_and_eviction", func(t *testing.T) {
+		under := &countingStorage{Storage: NewInMemory()}
+		require.NoError(t, under.AddMany(ctx, []URLEntry{
+			{ShortURL: "a", OriginalURL: "http://a.ru"},
+			{ShortURL: "b", OriginalURL: "http://b.ru"},
+			{ShortURL: "c", OriginalURL: "http://c.ru"},
+		}, "user"))
+
+		s := NewCachedStorage(under, 2, time.Minute)
+
+		for _, short := range []string{"a", "b", "a", "c", "a", "b"} {
+			_, err := s.GetByShort(ctx, short)
+			require.NoError(t, err)
+		}
+
+		// "b" is evicted by "c" as the least recently used.
+		require.Equal(t, 4, under.gets)
+		require.Equal(t, CacheStats{Hits: 2, Misses: 4, Size: 2}, s.Stats())
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		under := &countingStorage{Storage: NewInMemory()}
+		require.NoError(t, under.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru"}, "user"))
+
+		now := time.Now()
+		s := NewCachedStorage(under, 10, time.Minute)
+		s.now = func() time.Time { return now }
+
+		_, err := s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+
+		now = now.Add(59 * time.Second)
+		_, err = s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, 1, under.gets)
+
+		now = now.Add(time.Second)
+		_, err = s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, 2, under.gets)
+	})
+
+	t.Run("negative_caching", func(t *testing.T) {
+		under := &countingStorage{Storage: NewInMemory()}
+		s := NewCachedStorage(under, 10, time.Minute)
+
+		for i := 0; i < 2; i++ {
+			_, err := s.GetByShort(ctx, "a")
+			require.ErrorIs(t, err, ErrNotFound)
+		}
+		require.Equal(t, 1, under.gets)
+
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru"}, "user"))
+
+		u, err := s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "http://a.ru", u.OriginalURL)
+	})
+
+	t.Run("invalidation_on_delete", func(t *testing.T) {
+		s := NewCachedStorage(NewInMemory(), 10, time.Minute)
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru"}, "user"))
+
+		u, err := s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.False(t, u.Deleted)
+
+		_, err = s.MarkDeleted(ctx, EntryToDelete{ShortURL: "a", UserID: "user"})
+		require.NoError(t, err)
+
+		u, err = s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.True(t, u.Deleted)
+	})
+
+	t.Run("returns_copies", func(t *testing.T) {
+		s := NewCachedStorage(NewInMemory(), 10, time.Minute)
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru"}, "user"))
+
+		u, err := s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		u.OriginalURL = "changed"
+
+		u, err = s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "http://a.ru", u.OriginalURL)
+	})
+
+	t.Run("invalidation_during_lookup", func(t *testing.T) {
+		under := &hookStorage{Storage: NewInMemory()}
+		require.NoError(t, under.AddMany(ctx, []URLEntry{
+			{ShortURL: "a", OriginalURL: "http://a.ru"},
+			{ShortURL: "b", OriginalURL: "http://b.ru"},
+		}, "user"))
+
+		s := NewCachedStorage(under, 10, time.Minute)
+
+		// A write to another short url does not keep the result out of cache.
+		under.onGet = func(string) { s.invalidate("b") }
+		_, err := s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+
+		// A result fetched before the write to its short url may be stale, so it is not cached.
+		_, err = s.GetByShort(ctx, "b")
+		require.NoError(t, err)
+
+		require.Equal(t, 1, s.Stats().Size)
+		require.Empty(t, s.fetches)
+
+		under.onGet = func(string) {}
+		_, err = s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, CacheStats{Hits: 1, Misses: 2, Size: 1}, s.Stats())
+	})
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// aggregateClicks computes stats over events of a single short url.
+// Used by storages that cannot aggregate events themselves.
+func aggregateClicks(events []ClickEvent, topReferrers int) *ClickStats {
+	c := newClickCounter()
+	for _, e := range events {
+		c.add(e)
+	}
+
+	return c.stats(topReferrers)
+}
+
+// clickCounter aggregates click events of a single short url as they are added.
+type clickCounter struct {
+	total     int64
+	daily     map[time.Time]int64
+	referrers map[string]int64
+}
+
+func newClickCounter() *clickCounter {
+	return &clickCounter{
+		daily:     make(map[time.Time]int64),
+		referrers: make(map[string]int64),
+	}
+}
+
+// add counts the event.
+func (c *clickCounter) add(e ClickEvent) {
+	c.total++
+
+	y, m, d := e.Time.UTC().Date()
+	c.daily[time.Date(y, m, d, 0, 0, 0, 0, time.UTC)]++
+
+	if e.Referrer != "" {
+		c.referrers[e.Referrer]++
+	}
+}
+
+// stats returns the counted stats with at most topReferrers referrers.
+func (c *clickCounter) stats(topReferrers int) *ClickStats {
+	stats := &ClickStats{Total: c.total}
+
+	for day, n := range c.daily {
+		stats.Daily = append(stats.Daily, DailyClicks{Day: day, Clicks: n})
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Day.Before(stats.Daily[j].Day)
+	})
+
+	for ref, n := range c.referrers {
+		stats.TopReferrers = append(stats.TopReferrers, ReferrerClicks{Referrer: ref, Clicks: n})
+	}
+	sort.Slice(stats.TopReferrers, func(i, j int) bool {
+		a, b := stats.TopReferrers[i], stats.TopReferrers[j]
+		if a.Clicks != b.Clicks {
+			return a.Clicks > b.Clicks
+		}
+		return a.Referrer < b.Referrer
+	})
+	if len(stats.TopReferrers) > topReferrers {
+		stats.TopReferrers = stats.TopReferrers[:topReferrers]
+	}
+
+	return stats
+}
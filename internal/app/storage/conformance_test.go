@@ -0,0 +1,599 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/KonBal/url-shortener/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorageConformance runs the contract every Storage implementation must follow.
+// newStorage must return an empty storage on each call.
+func testStorageConformance(t *testing.T, newStorage func(t *testing.T) Storage) {
+	t.Run("add_and_get", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		expires := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru", ExpiresAt: &expires, MaxClicks: 3}, "user"))
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "sale", OriginalURL: "http://sale.ru", Alias: true}, "user"))
+		require.NoError(t, s.AddMany(ctx, []URLEntry{
+			{ShortURL: "b", OriginalURL: "http://b.ru"},
+			{ShortURL: "c", OriginalURL: "http://c.ru"},
+		}, "another"))
+
+		a, err := s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "http://a.ru", a.OriginalURL)
+		require.Equal(t, "user", a.CreatedBy)
+		require.WithinDuration(t, time.Now(), a.CreatedAt, time.Minute)
+		require.Equal(t, int64(3), a.MaxClicks)
+		require.NotNil(t, a.ExpiresAt)
+		require.True(t, expires.Equal(*a.ExpiresAt))
+		require.False(t, a.Deleted)
+
+		sale, err := s.GetByOriginal(ctx, "http://sale.ru")
+		require.NoError(t, err)
+		require.Equal(t, "sale", sale.ShortURL)
+		require.True(t, sale.Alias)
+
+		urls, err := s.GetURLsCreatedBy(ctx, "another", URLFilter{IncludeDeleted: true})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"b", "c"}, shortURLs(urls))
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		_, err := s.GetByShort(ctx, "missing")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		_, err = s.GetByOriginal(ctx, "http://missing.ru")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		require.ErrorIs(t, s.CountClick(ctx, "missing"), ErrNotFound)
+
+		urls, err := s.GetURLsCreatedBy(ctx, "nobody", URLFilter{IncludeDeleted: true})
+		require.NoError(t, err)
+		require.Empty(t, urls)
+
+		stats, err := s.GetClickStats(ctx, "missing", 10)
+		require.NoError(t, err)
+		require.Zero(t, stats.Total)
+	})
+
+	t.Run("uniqueness", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru", Alias: true}, "user"))
+
+		require.ErrorIs(t, s.Add(ctx, URLEntry{ShortURL: "b", OriginalURL: "http://a.ru"}, "user"), ErrNotUnique)
+		require.ErrorIs(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://b.ru", Alias: true}, "user"), ErrShortURLTaken)
+		require.ErrorIs(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://b.ru"}, "user"), ErrShortURLTaken)
+
+		err := s.AddMany(ctx, []URLEntry{
+			{ShortURL: "c", OriginalURL: "http://c.ru"},
+			{ShortURL: "d", OriginalURL: "http://a.ru"},
+		}, "user")
+		require.ErrorIs(t, err, ErrNotUnique)
+
+		err = s.AddMany(ctx, []URLEntry{
+			{ShortURL: "e", OriginalURL: "http://e.ru"},
+			{ShortURL: "f", OriginalURL: "http://e.ru"},
+		}, "user")
+		require.ErrorIs(t, err, ErrNotUnique)
+
+		// Failed batches are not saved partially.
+		for _, short := range []string{"c", "e"} {
+			_, err = s.GetByShort(ctx, short)
+			require.ErrorIs(t, err, ErrNotFound)
+		}
+	})
+
+	t.Run("add_or_get", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru"}, "user"))
+
+		res, err := s.AddOrGetMany(ctx, []URLEntry{
+			{ShortURL: "b", OriginalURL: "http://b.ru"},
+			{ShortURL: "c", OriginalURL: "http://a.ru"},
+			{ShortURL: "d", OriginalURL: "http://b.ru"},
+		}, "user")
+		require.NoError(t, err)
+		require.Equal(t, []AddResult{
+			{ShortURL: "b"},
+			{ShortURL: "a", Existed: true},
+			{ShortURL: "b", Existed: true},
+		}, res)
+
+		_, err = s.GetByShort(ctx, "b")
+		require.NoError(t, err)
+		for _, short := range []string{"c", "d"} {
+			_, err = s.GetByShort(ctx, short)
+			require.ErrorIs(t, err, ErrNotFound)
+		}
+
+		// A taken short url fails the whole batch.
+		_, err = s.AddOrGetMany(ctx, []URLEntry{
+			{ShortURL: "e", OriginalURL: "http://e.ru"},
+			{ShortURL: "a", OriginalURL: "http://f.ru"},
+		}, "user")
+		require.ErrorIs(t, err, ErrShortURLTaken)
+
+		_, err = s.GetByShort(ctx, "e")
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("list_filter", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		for _, short := range []string{"e", "d", "c", "b", "a"} {
+			require.NoError(t, s.Add(ctx, URLEntry{ShortURL: short, OriginalURL: "http://" + short + ".ru/Path"}, "user"))
+		}
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "f", OriginalURL: "http://f.ru"}, "another"))
+		_, err := s.MarkDeleted(ctx, EntryToDelete{ShortURL: "c", UserID: "user"})
+		require.NoError(t, err)
+
+		all, err := s.GetURLsCreatedBy(ctx, "user", URLFilter{IncludeDeleted: true})
+		require.NoError(t, err)
+		require.Len(t, all, 5)
+		for i := 1; i < len(all); i++ {
+			require.True(t, cursorLess(URLCursor{CreatedAt: all[i-1].CreatedAt, ShortURL: all[i-1].ShortURL},
+				all[i].CreatedAt, all[i].ShortURL))
+		}
+
+		// Pages follow each other without gaps.
+		var paged []URLEntry
+		filter := URLFilter{Limit: 2, IncludeDeleted: true}
+		for {
+			page, err := s.GetURLsCreatedBy(ctx, "user", filter)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page), 2)
+			if len(page) == 0 {
+				break
+			}
+
+			paged = append(paged, page...)
+			last := page[len(page)-1]
+			filter.After = &URLCursor{CreatedAt: last.CreatedAt, ShortURL: last.ShortURL}
+		}
+		require.Equal(t, shortURLs(all), shortURLs(paged))
+
+		live, err := s.GetURLsCreatedBy(ctx, "user", URLFilter{})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"a", "b", "d", "e"}, shortURLs(live))
+
+		found, err := s.GetURLsCreatedBy(ctx, "user", URLFilter{Search: "B.RU/p"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"b"}, shortURLs(found))
+
+		created, err := s.GetURLsCreatedBy(ctx, "user", URLFilter{
+			IncludeDeleted: true,
+			CreatedAfter:   all[0].CreatedAt.Add(-time.Hour),
+			CreatedBefore:  all[0].CreatedAt.Add(time.Hour),
+		})
+		require.NoError(t, err)
+		require.Len(t, created, 5)
+
+		created, err = s.GetURLsCreatedBy(ctx, "user", URLFilter{CreatedAfter: all[len(all)-1].CreatedAt.Add(time.Hour)})
+		require.NoError(t, err)
+		require.Empty(t, created)
+	})
+
+	t.Run("deletion_ownership", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		require.NoError(t, s.AddMany(ctx, []URLEntry{
+			{ShortURL: "a", OriginalURL: "http://a.ru"},
+			{ShortURL: "b", OriginalURL: "http://b.ru"},
+		}, "user"))
+
+		owned, err := s.MarkDeleted(ctx)
+		require.NoError(t, err)
+		require.Empty(t, owned)
+
+		owned, err = s.MarkDeleted(ctx,
+			EntryToDelete{ShortURL: "a", UserID: "user"},
+			EntryToDelete{ShortURL: "b", UserID: "another"},
+			EntryToDelete{ShortURL: "missing", UserID: "user"},
+		)
+		require.NoError(t, err)
+		require.Equal(t, []EntryToDelete{{ShortURL: "a", UserID: "user"}}, owned)
+
+		a, err := s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.True(t, a.Deleted)
+
+		b, err := s.GetByShort(ctx, "b")
+		require.NoError(t, err)
+		require.False(t, b.Deleted)
+
+		// Deleting twice is not an error, the entry is still reported owned.
+		owned, err = s.MarkDeleted(ctx, EntryToDelete{ShortURL: "a", UserID: "user"})
+		require.NoError(t, err)
+		require.Equal(t, []EntryToDelete{{ShortURL: "a", UserID: "user"}}, owned)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		require.NoError(t, s.AddMany(ctx, []URLEntry{
+			{ShortURL: "a", OriginalURL: "http://a.ru"},
+			{ShortURL: "b", OriginalURL: "http://b.ru"},
+		}, "user"))
+
+		before := time.Now().Add(-time.Second)
+		_, err := s.MarkDeleted(ctx,
+			EntryToDelete{ShortURL: "a", UserID: "user"},
+			EntryToDelete{ShortURL: "b", UserID: "user"},
+		)
+		require.NoError(t, err)
+
+		a, err := s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.True(t, a.Deleted)
+		require.NotNil(t, a.DeletedAt)
+		require.True(t, a.DeletedAt.After(before))
+
+		require.NoError(t, s.RestoreDeleted(ctx))
+		require.NoError(t, s.RestoreDeleted(ctx,
+			EntryToDelete{ShortURL: "a", UserID: "user"},
+			EntryToDelete{ShortURL: "b", UserID: "another"},
+			EntryToDelete{ShortURL: "missing", UserID: "user"},
+		))
+
+		a, err = s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.False(t, a.Deleted)
+		require.Nil(t, a.DeletedAt)
+
+		b, err := s.GetByShort(ctx, "b")
+		require.NoError(t, err)
+		require.True(t, b.Deleted)
+
+		// Restoring twice is not an error.
+		require.NoError(t, s.RestoreDeleted(ctx, EntryToDelete{ShortURL: "a", UserID: "user"}))
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		require.NoError(t, s.AddMany(ctx, []URLEntry{
+			{ShortURL: "a", OriginalURL: "http://a.ru"},
+			{ShortURL: "b", OriginalURL: "http://b.ru"},
+			{ShortURL: "c", OriginalURL: "http://c.ru"},
+		}, "user"))
+		require.NoError(t, s.AddClickEvents(ctx,
+			ClickEvent{ShortURL: "a", Time: time.Now().UTC(), Referrer: "http://ref.ru"},
+			ClickEvent{ShortURL: "c", Time: time.Now().UTC()},
+		))
+		_, err := s.MarkDeleted(ctx,
+			EntryToDelete{ShortURL: "a", UserID: "user"},
+			EntryToDelete{ShortURL: "b", UserID: "user"},
+		)
+		require.NoError(t, err)
+
+		// Cached lookups must not see purged entries.
+		_, err = s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+
+		purged, err := s.PurgeDeleted(ctx, time.Now().Add(-time.Hour), 10)
+		require.NoError(t, err)
+		require.Empty(t, purged)
+
+		before := time.Now().Add(time.Hour)
+
+		first, err := s.PurgeDeleted(ctx, before, 1)
+		require.NoError(t, err)
+		require.Len(t, first, 1)
+
+		rest, err := s.PurgeDeleted(ctx, before, 10)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"a", "b"}, append(first, rest...))
+
+		for _, short := range []string{"a", "b"} {
+			_, err := s.GetByShort(ctx, short)
+			require.ErrorIs(t, err, ErrNotFound)
+		}
+
+		urls, err := s.GetURLsCreatedBy(ctx, "user", URLFilter{IncludeDeleted: true})
+		require.NoError(t, err)
+		require.Equal(t, []string{"c"}, shortURLs(urls))
+
+		// Short and original urls of purged entries are free again.
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru"}, "another"))
+
+		// The new owner of the code does not inherit clicks of the purged entry.
+		stats, err := s.GetClickStats(ctx, "a", 10)
+		require.NoError(t, err)
+		require.Zero(t, stats.Total)
+		require.Empty(t, stats.TopReferrers)
+
+		stats, err = s.GetClickStats(ctx, "c", 10)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), stats.Total)
+	})
+
+	t.Run("no_user", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru"}, ""))
+		require.NoError(t, s.AddMany(ctx, []URLEntry{{ShortURL: "b", OriginalURL: "http://b.ru"}}, ""))
+
+		urls, err := s.GetURLsCreatedBy(ctx, "", URLFilter{})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"a", "b"}, shortURLs(urls))
+
+		owned, err := s.MarkDeleted(ctx, EntryToDelete{ShortURL: "a", UserID: ""})
+		require.NoError(t, err)
+		require.Equal(t, []EntryToDelete{{ShortURL: "a"}}, owned)
+
+		purged, err := s.PurgeDeleted(ctx, time.Now().Add(time.Hour), 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a"}, purged)
+
+		urls, err = s.GetURLsCreatedBy(ctx, "", URLFilter{IncludeDeleted: true})
+		require.NoError(t, err)
+		require.Equal(t, []string{"b"}, shortURLs(urls))
+	})
+
+	t.Run("update_original", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		require.NoError(t, s.AddMany(ctx, []URLEntry{
+			{ShortURL: "a", OriginalURL: "http://a.ru"},
+			{ShortURL: "b", OriginalURL: "http://b.ru"},
+			{ShortURL: "c", OriginalURL: "http://c.ru"},
+		}, "user"))
+		_, err := s.MarkDeleted(ctx, EntryToDelete{ShortURL: "c", UserID: "user"})
+		require.NoError(t, err)
+
+		// Cached lookups must see the update.
+		_, err = s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+
+		require.NoError(t, s.UpdateOriginal(ctx, "a", "user", "http://new.ru"))
+		require.NoError(t, s.UpdateOriginal(ctx, "a", "user", "http://new.ru"))
+
+		a, err := s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "http://new.ru", a.OriginalURL)
+
+		byOrig, err := s.GetByOriginal(ctx, "http://new.ru")
+		require.NoError(t, err)
+		require.Equal(t, "a", byOrig.ShortURL)
+
+		_, err = s.GetByOriginal(ctx, "http://a.ru")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		// The old original url is free again.
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "d", OriginalURL: "http://a.ru"}, "user"))
+
+		require.ErrorIs(t, s.UpdateOriginal(ctx, "a", "user", "http://b.ru"), ErrNotUnique)
+		require.ErrorIs(t, s.UpdateOriginal(ctx, "a", "another", "http://other.ru"), ErrNotFound)
+		require.ErrorIs(t, s.UpdateOriginal(ctx, "c", "user", "http://other.ru"), ErrNotFound)
+		require.ErrorIs(t, s.UpdateOriginal(ctx, "missing", "user", "http://other.ru"), ErrNotFound)
+
+		a, err = s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "http://new.ru", a.OriginalURL)
+	})
+
+	t.Run("click_limit", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru", MaxClicks: 2}, "user"))
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "b", OriginalURL: "http://b.ru"}, "user"))
+
+		require.NoError(t, s.CountClick(ctx, "a"))
+		require.NoError(t, s.CountClick(ctx, "a"))
+		require.ErrorIs(t, s.CountClick(ctx, "a"), ErrClickLimitReached)
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, s.CountClick(ctx, "b"))
+		}
+
+		a, err := s.GetByShort(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, int64(2), a.Clicks)
+	})
+
+	t.Run("click_stats", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		day := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+		require.NoError(t, s.AddClickEvents(ctx,
+			ClickEvent{ShortURL: "a", Time: day, Referrer: "http://r1"},
+			ClickEvent{ShortURL: "a", Time: day.Add(24 * time.Hour), Referrer: "http://r2"},
+			ClickEvent{ShortURL: "a", Time: day.Add(25 * time.Hour), Referrer: "http://r2"},
+			ClickEvent{ShortURL: "b", Time: day},
+		))
+
+		stats, err := s.GetClickStats(ctx, "a", 1)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), stats.Total)
+		require.Len(t, stats.Daily, 2)
+		require.True(t, day.Truncate(24*time.Hour).Equal(stats.Daily[0].Day))
+		require.Equal(t, []ReferrerClicks{{Referrer: "http://r2", Clicks: 2}}, stats.TopReferrers)
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		ctx := context.TODO()
+		s := newStorage(t)
+
+		const n = 50
+		require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "limited", OriginalURL: "http://limited.ru", MaxClicks: n / 2}, "user"))
+
+		var clicks atomic.Int64
+		// Assertions fail the test only from its own goroutine, so workers report errors instead.
+		errs := make(chan error, n)
+
+		for i := 0; i < n; i++ {
+			go func(i int) {
+				short := fmt.Sprintf("s%d", i)
+				if err := s.Add(ctx, URLEntry{ShortURL: short, OriginalURL: "http://" + short + ".ru"}, "user"); err != nil {
+					errs <- fmt.Errorf("add %s: %w", short, err)
+					return
+				}
+
+				if _, err := s.GetByShort(ctx, short); err != nil {
+					errs <- fmt.Errorf("get %s: %w", short, err)
+					return
+				}
+
+				err := s.CountClick(ctx, "limited")
+				switch {
+				case err == nil:
+					clicks.Add(1)
+				case errors.Is(err, ErrClickLimitReached):
+					err = nil
+				default:
+					err = fmt.Errorf("count click: %w", err)
+				}
+				errs <- err
+			}(i)
+		}
+
+		for i := 0; i < n; i++ {
+			require.NoError(t, <-errs)
+		}
+
+		urls, err := s.GetURLsCreatedBy(ctx, "user", URLFilter{IncludeDeleted: true})
+		require.NoError(t, err)
+		require.Len(t, urls, n+1)
+		require.Equal(t, int64(n/2), clicks.Load())
+	})
+
+	t.Run("context_cancellation", func(t *testing.T) {
+		s := newStorage(t)
+		require.NoError(t, s.Add(context.TODO(), URLEntry{ShortURL: "a", OriginalURL: "http://a.ru"}, "user"))
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+
+		require.ErrorIs(t, s.Add(ctx, URLEntry{ShortURL: "b", OriginalURL: "http://b.ru"}, "user"), context.Canceled)
+		require.ErrorIs(t, s.AddMany(ctx, []URLEntry{{ShortURL: "c", OriginalURL: "http://c.ru"}}, "user"), context.Canceled)
+		_, err := s.MarkDeleted(ctx, EntryToDelete{ShortURL: "a", UserID: "user"})
+		require.ErrorIs(t, err, context.Canceled)
+		require.ErrorIs(t, s.UpdateOriginal(ctx, "a", "user", "http://b.ru"), context.Canceled)
+		require.ErrorIs(t, s.RestoreDeleted(ctx, EntryToDelete{ShortURL: "a", UserID: "user"}), context.Canceled)
+		require.ErrorIs(t, s.CountClick(ctx, "a"), context.Canceled)
+		require.ErrorIs(t, s.AddClickEvents(ctx, ClickEvent{ShortURL: "a", Time: time.Now()}), context.Canceled)
+		require.ErrorIs(t, s.Ping(ctx), context.Canceled)
+
+		_, err = s.GetByShort(ctx, "a")
+		require.ErrorIs(t, err, context.Canceled)
+		_, err = s.GetByOriginal(ctx, "http://a.ru")
+		require.ErrorIs(t, err, context.Canceled)
+		_, err = s.GetURLsCreatedBy(ctx, "user", URLFilter{IncludeDeleted: true})
+		require.ErrorIs(t, err, context.Canceled)
+		_, err = s.PurgeDeleted(ctx, time.Now(), 10)
+		require.ErrorIs(t, err, context.Canceled)
+		_, err = s.GetClickStats(ctx, "a", 10)
+		require.ErrorIs(t, err, context.Canceled)
+
+		a, err := s.GetByShort(context.TODO(), "a")
+		require.NoError(t, err)
+		require.False(t, a.Deleted)
+	})
+}
+
+func shortURLs(urls []URLEntry) []string {
+	res := make([]string, len(urls))
+	for i, u := range urls {
+		res[i] = u.ShortURL
+	}
+	return res
+}
+
+func TestInMemoryConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) Storage {
+		return NewInMemory()
+	})
+}
+
+func TestCachedConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) Storage {
+		return NewCachedStorage(NewInMemory(), 16, time.Minute)
+	})
+}
+
+func TestFileConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) Storage {
+		s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"), &seqGen{})
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestBoltConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) Storage {
+		s, err := NewBoltStorage(filepath.Join(t.TempDir(), "urls.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+// TestDBConformance runs against a Postgres instance given by TEST_DATABASE_DSN.
+// Every storage gets its own schema which is dropped afterwards.
+func TestDBConformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	testStorageConformance(t, func(t *testing.T) Storage {
+		admin, err := sql.Open("pgx", dsn)
+		require.NoError(t, err)
+		t.Cleanup(func() { admin.Close() })
+
+		schema := fmt.Sprintf("conformance_%d", time.Now().UnixNano())
+		_, err = admin.Exec("create schema " + schema)
+		require.NoError(t, err)
+		t.Cleanup(func() { admin.Exec("drop schema " + schema + " cascade") })
+
+		db, err := sql.Open("pgx", withSearchPath(dsn, schema))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+
+		s := NewDBStorage(db)
+		require.NoError(t, s.Bootstrap(migrations.SQLFiles))
+		return s
+	})
+}
+
+func withSearchPath(dsn, schema string) string {
+	switch {
+	case !strings.Contains(dsn, "://"):
+		return dsn + " search_path=" + schema
+	case strings.Contains(dsn, "?"):
+		return dsn + "&search_path=" + schema
+	default:
+		return dsn + "?search_path=" + schema
+	}
+}
@@ -7,12 +7,16 @@ import (
 	"fmt"
 	"io/fs"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/pressly/goose/v3"
 )
 
+// Name of the index that keeps short urls unique, see migrations.
+const shortURLUniqueIndex = "urls_short_url_idx"
+
 // DB.
 type DBStorage struct {
 	db *sql.DB
@@ -26,22 +30,32 @@ func NewDBStorage(db *sql.DB) *DBStorage {
 // Add saves entry to DB.
 func (s *DBStorage) Add(ctx context.Context, u URLEntry, userID string) error {
 	_, err := s.db.ExecContext(ctx,
-		`insert into urls(short_url, original_url, created_by) values ($1, $2, $3)`,
-		u.ShortURL, u.OriginalURL, userID)
+		`insert into urls(short_url, original_url, created_by, alias, expires_at, max_clicks)
+		values ($1, $2, $3, $4, $5, $6)`,
+		u.ShortURL, u.OriginalURL, userID, u.Alias, u.ExpiresAt, u.MaxClicks)
 
 	if err != nil {
-		var pgErr *pgconn.PgError
-		c := errors.As(err, &pgErr)
-		if c && pgErr.Code == pgerrcode.UniqueViolation {
-			return ErrNotUnique
-		}
-
-		return fmt.Errorf("db: %w", err)
+		return uniqueViolationError(err)
 	}
 
 	return nil
 }
 
+// uniqueViolationError translates violation of unique constraints into storage errors:
+// ErrShortURLTaken for a short url collision, ErrNotUnique for a duplicate original url.
+func uniqueViolationError(err error) error {
+	var pgErr *pgconn.PgError
+	c := errors.As(err, &pgErr)
+	if c && pgErr.Code == pgerrcode.UniqueViolation {
+		if pgErr.ConstraintName == shortURLUniqueIndex {
+			return ErrShortURLTaken
+		}
+		return ErrNotUnique
+	}
+
+	return fmt.Errorf("db: %w", err)
+}
+
 // AddMany saves several entries to DB.
 func (s *DBStorage) AddMany(ctx context.Context, urls []URLEntry, userID string) error {
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -50,33 +64,83 @@ func (s *DBStorage) AddMany(ctx context.Context, urls []URLEntry, userID string)
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.PrepareContext(ctx, `insert into urls(short_url, original_url, created_by) values ($1, $2, $3)`)
+	stmt, err := tx.PrepareContext(ctx, `insert into urls(short_url, original_url, created_by, alias, expires_at, max_clicks)
+		values ($1, $2, $3, $4, $5, $6)`)
 	if err != nil {
 		return fmt.Errorf("db: %w", err)
 	}
 
 	for _, u := range urls {
-		_, err := stmt.ExecContext(ctx, u.ShortURL, u.OriginalURL, userID)
+		_, err := stmt.ExecContext(ctx, u.ShortURL, u.OriginalURL, userID, u.Alias, u.ExpiresAt, u.MaxClicks)
 		if err != nil {
 			tx.Rollback()
-			return fmt.Errorf("db: %w", err)
+			return uniqueViolationError(err)
 		}
 	}
 
 	return tx.Commit()
 }
 
+// AddOrGetMany adds entries with new original urls and finds existing ones for the rest
+// in one transaction. Nothing is added if a short url is taken.
+func (s *DBStorage) AddOrGetMany(ctx context.Context, urls []URLEntry, userID string) ([]AddResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.PrepareContext(ctx, `insert into urls(short_url, original_url, created_by, alias, expires_at, max_clicks)
+		values ($1, $2, $3, $4, $5, $6)
+		on conflict (original_url) do nothing
+		returning short_url`)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	existing, err := tx.PrepareContext(ctx, `select short_url from urls where original_url = $1`)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	results := make([]AddResult, len(urls))
+
+	for i, u := range urls {
+		var short string
+		err := insert.QueryRowContext(ctx, u.ShortURL, u.OriginalURL, userID, u.Alias, u.ExpiresAt, u.MaxClicks).Scan(&short)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			if err := existing.QueryRowContext(ctx, u.OriginalURL).Scan(&short); err != nil {
+				return nil, fmt.Errorf("db: %w", err)
+			}
+			results[i] = AddResult{ShortURL: short, Existed: true}
+		case err != nil:
+			return nil, uniqueViolationError(err)
+		default:
+			results[i] = AddResult{ShortURL: short}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	return results, nil
+}
+
 // GetByShort retrieves entry by short url.
 func (s *DBStorage) GetByShort(ctx context.Context, shortURL string) (*URLEntry, error) {
 	const query = `
-		select u.original_url, u.short_url, u.deleted
+		select u.original_url, u.short_url, u.deleted, u.deleted_at, u.alias, coalesce(u.created_by, ''), u.created_at,
+			u.expires_at, u.max_clicks, u.clicks
 		from urls as u
 		where u.short_url = $1;
 	`
 
 	var u URLEntry
 
-	err := s.db.QueryRowContext(ctx, query, shortURL).Scan(&u.OriginalURL, &u.ShortURL, &u.Deleted)
+	err := s.db.QueryRowContext(ctx, query, shortURL).Scan(&u.OriginalURL, &u.ShortURL, &u.Deleted, &u.DeletedAt, &u.Alias,
+		&u.CreatedBy, &u.CreatedAt, &u.ExpiresAt, &u.MaxClicks, &u.Clicks)
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
 		return nil, ErrNotFound
@@ -90,30 +154,65 @@ func (s *DBStorage) GetByShort(ctx context.Context, shortURL string) (*URLEntry,
 // GetByOriginal retrieves entry by original url.
 func (s *DBStorage) GetByOriginal(ctx context.Context, origURL string) (*URLEntry, error) {
 	const query = `
-		select u.original_url, u.short_url, u.deleted
+		select u.original_url, u.short_url, u.deleted, u.deleted_at, u.alias, coalesce(u.created_by, ''), u.created_at,
+			u.expires_at, u.max_clicks, u.clicks
 		from urls as u
 		where u.original_url = $1;
 	`
 
 	var u URLEntry
 
-	err := s.db.QueryRowContext(ctx, query, origURL).Scan(&u.OriginalURL, &u.ShortURL, &u.Deleted)
-	if err != nil {
+	err := s.db.QueryRowContext(ctx, query, origURL).Scan(&u.OriginalURL, &u.ShortURL, &u.Deleted, &u.DeletedAt, &u.Alias,
+		&u.CreatedBy, &u.CreatedAt, &u.ExpiresAt, &u.MaxClicks, &u.Clicks)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, ErrNotFound
+	case err != nil:
 		return nil, fmt.Errorf("db: %w", err)
 	}
 
 	return &u, nil
 }
 
-// GetURLsCreatedBy retrieves entries added by user.
-func (s *DBStorage) GetURLsCreatedBy(ctx context.Context, userID string) ([]URLEntry, error) {
-	const query = `
-		select u.short_url, u.original_url, u.deleted
+// GetURLsCreatedBy retrieves entries added by user matching the filter.
+func (s *DBStorage) GetURLsCreatedBy(ctx context.Context, userID string, filter URLFilter) ([]URLEntry, error) {
+	conditions := []string{"u.created_by = $1"}
+	args := []any{userID}
+
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if !filter.IncludeDeleted {
+		conditions = append(conditions, "not u.deleted")
+	}
+	if filter.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(u.created_at, u.short_url) > (%s, %s)",
+			arg(filter.After.CreatedAt), arg(filter.After.ShortURL)))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, "u.created_at > "+arg(filter.CreatedAfter))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, "u.created_at < "+arg(filter.CreatedBefore))
+	}
+	if filter.Search != "" {
+		conditions = append(conditions, "strpos(lower(u.original_url), lower("+arg(filter.Search)+")) > 0")
+	}
+
+	query := `
+		select u.short_url, u.original_url, u.deleted, u.deleted_at, u.alias, coalesce(u.created_by, ''), u.created_at,
+			u.expires_at, u.max_clicks, u.clicks
 		from urls as u
-		where u.created_by = $1;
-	`
+		where ` + strings.Join(conditions, " and ") + `
+		order by u.created_at, u.short_url`
 
-	rows, err := s.db.QueryContext(ctx, query, userID)
+	if filter.Limit > 0 {
+		query += " limit " + arg(filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("db: %w", err)
 	}
@@ -123,7 +222,8 @@ func (s *DBStorage) GetURLsCreatedBy(ctx context.Context, userID string) ([]URLE
 
 	for rows.Next() && err == nil {
 		var u URLEntry
-		err = rows.Scan(&u.ShortURL, &u.OriginalURL, &u.Deleted)
+		err = rows.Scan(&u.ShortURL, &u.OriginalURL, &u.Deleted, &u.DeletedAt, &u.Alias, &u.CreatedBy, &u.CreatedAt,
+			&u.ExpiresAt, &u.MaxClicks, &u.Clicks)
 		urls = append(urls, u)
 	}
 
@@ -141,8 +241,24 @@ func (s *DBStorage) GetURLsCreatedBy(ctx context.Context, userID string) ([]URLE
 	return urls, nil
 }
 
-// MarkDeleted sets deleted flag to the entries in DB.
-func (s *DBStorage) MarkDeleted(ctx context.Context, urls ...EntryToDelete) error {
+// MarkDeleted sets deleted flag to the entries in DB and returns the owned ones.
+func (s *DBStorage) MarkDeleted(ctx context.Context, urls ...EntryToDelete) ([]EntryToDelete, error) {
+	return s.updateOwned(ctx, "deleted = true, deleted_at = coalesce(u.deleted_at, now())", urls)
+}
+
+// RestoreDeleted clears deleted flag of the entries in DB.
+func (s *DBStorage) RestoreDeleted(ctx context.Context, urls ...EntryToDelete) error {
+	_, err := s.updateOwned(ctx, "deleted = false, deleted_at = null", urls)
+	return err
+}
+
+// updateOwned runs update with the set clause for the entries created by the given users
+// and returns the urls of the updated entries.
+func (s *DBStorage) updateOwned(ctx context.Context, set string, urls []EntryToDelete) ([]EntryToDelete, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
 	var conditions []string
 	var args []any
 
@@ -155,16 +271,211 @@ func (s *DBStorage) MarkDeleted(ctx context.Context, urls ...EntryToDelete) erro
 
 	query := `
 		update urls as u
-		set deleted = true
-		where ` + strings.Join(conditions, " or ") + ";"
+		set ` + set + `
+		where ` + strings.Join(conditions, " or ") + `
+		returning coalesce(u.created_by, ''), u.short_url;`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+	defer rows.Close()
+
+	var owned []EntryToDelete
+	for rows.Next() {
+		var u EntryToDelete
+		if err := rows.Scan(&u.UserID, &u.ShortURL); err != nil {
+			return nil, fmt.Errorf("db: %w", err)
+		}
+		owned = append(owned, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	return owned, nil
+}
+
+// UpdateOriginal changes original url of the entry if it is created by the user and not deleted.
+func (s *DBStorage) UpdateOriginal(ctx context.Context, shortURL string, userID string, origURL string) error {
+	const query = `
+		update urls as u
+		set original_url = $1
+		where u.short_url = $2 and u.created_by = $3 and not u.deleted;
+	`
 
-	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+	res, err := s.db.ExecContext(ctx, query, origURL, shortURL, userID)
+	if err != nil {
+		return uniqueViolationError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
 		return fmt.Errorf("db: %w", err)
 	}
 
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// PurgeDeleted removes at most limit entries deleted before deletedBefore with their click events.
+// It returns short urls of the removed entries.
+func (s *DBStorage) PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) ([]string, error) {
+	const query = `
+		with purged as (
+			delete from urls
+			where id in (
+				select id from urls
+				where deleted and deleted_at < $1
+				order by deleted_at
+				limit $2
+			)
+			returning short_url
+		), purged_clicks as (
+			delete from clicks
+			where short_url in (select short_url from purged)
+		)
+		select short_url from purged;
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, deletedBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+	defer rows.Close()
+
+	var purged []string
+	for rows.Next() {
+		var short string
+		if err := rows.Scan(&short); err != nil {
+			return nil, fmt.Errorf("db: %w", err)
+		}
+		purged = append(purged, short)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	return purged, nil
+}
+
+// CountClick increases the click counter of the entry unless its click limit is reached.
+func (s *DBStorage) CountClick(ctx context.Context, shortURL string) error {
+	const query = `
+		update urls as u
+		set clicks = u.clicks + 1
+		where u.short_url = $1 and (u.max_clicks = 0 or u.clicks < u.max_clicks);
+	`
+
+	res, err := s.db.ExecContext(ctx, query, shortURL)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if n == 0 {
+		if _, err := s.GetByShort(ctx, shortURL); err != nil {
+			return err
+		}
+		return ErrClickLimitReached
+	}
+
 	return nil
 }
 
+// AddClickEvents saves click events to DB.
+func (s *DBStorage) AddClickEvents(ctx context.Context, events ...ClickEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `insert into clicks(short_url, clicked_at, referrer, user_agent, ip_hash)
+		values ($1, $2, $3, $4, $5)`)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	for _, e := range events {
+		_, err := stmt.ExecContext(ctx, e.ShortURL, e.Time, e.Referrer, e.UserAgent, e.IPHash)
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetClickStats returns aggregated click events of the short url.
+func (s *DBStorage) GetClickStats(ctx context.Context, shortURL string, topReferrers int) (*ClickStats, error) {
+	const dailyQuery = `
+		select date_trunc('day', c.clicked_at at time zone 'UTC') as day, count(*)
+		from clicks as c
+		where c.short_url = $1
+		group by day
+		order by day;
+	`
+
+	const referrersQuery = `
+		select c.referrer, count(*) as n
+		from clicks as c
+		where c.short_url = $1 and c.referrer <> ''
+		group by c.referrer
+		order by n desc, c.referrer
+		limit $2;
+	`
+
+	var stats ClickStats
+
+	rows, err := s.db.QueryContext(ctx, dailyQuery, shortURL)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d DailyClicks
+		if err := rows.Scan(&d.Day, &d.Clicks); err != nil {
+			return nil, fmt.Errorf("db: %w", err)
+		}
+		d.Day = time.Date(d.Day.Year(), d.Day.Month(), d.Day.Day(), 0, 0, 0, 0, time.UTC)
+		stats.Daily = append(stats.Daily, d)
+		stats.Total += d.Clicks
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	rows, err = s.db.QueryContext(ctx, referrersQuery, shortURL, topReferrers)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r ReferrerClicks
+		if err := rows.Scan(&r.Referrer, &r.Clicks); err != nil {
+			return nil, fmt.Errorf("db: %w", err)
+		}
+		stats.TopReferrers = append(stats.TopReferrers, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	return &stats, nil
+}
+
 // Bootstrap applies migrations.
 func (s *DBStorage) Bootstrap(migrationFiles fs.FS) error {
 	if err := applyMigrations(s.db, migrationFiles); err != nil {
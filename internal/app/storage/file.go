@@ -1,12 +1,16 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
 type jsonFileWriter struct {
@@ -28,19 +32,75 @@ func (w *jsonFileWriter) Close() error {
 	return w.file.Close()
 }
 
+// Kinds of records in the log.
+const (
+	// Full state of an entry, replaces earlier records with the same short url.
+	recordPut = ""
+	// Tombstone that marks the entry with the short url deleted.
+	recordDelete = "delete"
+	// Record that clears the deleted mark of the entry with the short url.
+	recordRestore = "restore"
+	// Record that removes the entry with the short url completely.
+	recordPurge = "purge"
+	// Header of a batch, the records that follow it are applied only when all of them are read.
+	recordBatch = "batch"
+	// Record that increases the click counter of the entry with the short url by its clicks.
+	recordClick = "click"
+)
+
 type fileEntry struct {
-	UUID        uint64 `json:"uuid"`
+	Op          string `json:"op,omitempty"`
+	Batch       int    `json:"batch,omitempty"`
+	UUID        uint64 `json:"uuid,omitempty"`
 	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	CreatedBy   string `json:"created_by"`
+	OriginalURL string `json:"original_url,omitempty"`
+	CreatedBy   string `json:"created_by,omitempty"`
 	Deleted     bool   `json:"deleted,omitempty"`
+	Alias       bool   `json:"alias,omitempty"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxClicks int64      `json:"max_clicks,omitempty"`
+	Clicks    int64      `json:"clicks,omitempty"`
+}
+
+func (e *fileEntry) toURLEntry() *URLEntry {
+	return &URLEntry{
+		ShortURL:    e.ShortURL,
+		OriginalURL: e.OriginalURL,
+		Deleted:     e.Deleted,
+		DeletedAt:   e.DeletedAt,
+		Alias:       e.Alias,
+		CreatedBy:   e.CreatedBy,
+		CreatedAt:   e.CreatedAt,
+		ExpiresAt:   e.ExpiresAt,
+		MaxClicks:   e.MaxClicks,
+		Clicks:      e.Clicks,
+	}
 }
 
 // Wtire writes to json file writer.
-func (w *jsonFileWriter) Write(row fileEntry) error {
+func (w *jsonFileWriter) Write(row any) error {
 	return w.encoder.Encode(row)
 }
 
+// WriteAll encodes rows and writes them with a single write call.
+// The write is not atomic, a crash may leave only some of the rows in the file.
+func (w *jsonFileWriter) WriteAll(rows ...any) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.file.Write(buf.Bytes())
+	return err
+}
+
 type jsonFileReader struct {
 	file    *os.File
 	decoder *json.Decoder
@@ -73,173 +133,965 @@ func (r *jsonFileReader) Read() (*fileEntry, error) {
 	return &row, nil
 }
 
+// Click event in the clicks file. A record with purge op drops earlier events of the short url.
+type fileClickEvent struct {
+	Op        string    `json:"op,omitempty"`
+	ShortURL  string    `json:"short_url"`
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPHash    string    `json:"ip_hash,omitempty"`
+}
+
+func (e *fileClickEvent) toClickEvent() ClickEvent {
+	return ClickEvent{
+		ShortURL:  e.ShortURL,
+		Time:      e.Time,
+		Referrer:  e.Referrer,
+		UserAgent: e.UserAgent,
+		IPHash:    e.IPHash,
+	}
+}
+
+// Compaction is checked every compactionPeriod and runs
+// when there are at least minDeadRecords superseded records and no fewer than live ones.
+// Clicks are counted in memory and written every clickFlushPeriod,
+// so a crash loses the clicks of at most one period.
+const (
+	compactionPeriod = 5 * time.Minute
+	minDeadRecords   = 1024
+	clickFlushPeriod = 10 * time.Second
+)
+
 // File storage.
+// The file is an append-only log of entry states and deletion tombstones.
+// It is replayed into in-memory indexes on startup and compacted in background.
 type FileStorage struct {
 	fname  string
+	mu     sync.RWMutex
 	writer *jsonFileWriter
-	idGen  interface {
+
+	entries    map[string]*fileEntry
+	byOriginal map[string]string
+	byUser     map[string][]string
+	// Number of records in the log superseded by later ones.
+	dead int
+	// Clicks counted in entries but not written to the log yet.
+	pendingClicks map[string]int64
+
+	clicksFname  string
+	clicksMu     sync.Mutex
+	clicksWriter *jsonFileWriter
+	// Aggregated live click events of each short url in the clicks file.
+	clickStats map[string]*clickCounter
+	clicksLive int
+	// Number of events of each short url in the clicks file dropped by its last purge record.
+	clicksDropped map[string]int
+	// Number of purge records and events dropped by them in the clicks file.
+	clicksDead int
+
+	idGen interface {
 		Next() uint64
 	}
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
 }
 
 // NewFileStorage created new file storage.
+// Click events are kept in a separate file next to the main one.
 func NewFileStorage(fname string, idGen interface {
 	Next() uint64
 }) (*FileStorage, error) {
+	s := &FileStorage{
+		fname:         fname,
+		entries:       make(map[string]*fileEntry),
+		byOriginal:    make(map[string]string),
+		byUser:        make(map[string][]string),
+		pendingClicks: make(map[string]int64),
+		clicksFname:   fname + ".clicks",
+		clickStats:    make(map[string]*clickCounter),
+		clicksDropped: make(map[string]int),
+		idGen:         idGen,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	if err := s.replayClicks(); err != nil {
+		return nil, err
+	}
+
 	w, err := newFileWriter(fname)
 	if err != nil {
 		return nil, err
 	}
+	s.writer = w
 
-	return &FileStorage{
-		fname:  fname,
-		writer: w,
-		idGen:  idGen,
-	}, nil
+	cw, err := newFileWriter(s.clicksFname)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	s.clicksWriter = cw
+
+	go s.runCompaction()
+
+	return s, nil
 }
 
-// Close closes file writer.
+// Close stops compaction, writes pending clicks and closes file writers.
+// Calls after the first one return its result.
 func (s *FileStorage) Close() error {
-	return s.writer.Close()
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.closeErr = errors.Join(s.flushClicks(), s.writer.Close(), s.clicksWriter.Close())
+	})
+
+	return s.closeErr
+}
+
+// replay reads the log into indexes.
+// A record that was not written completely, e.g. due to a crash, is cut off
+// together with the rest of its batch.
+func (s *FileStorage) replay() error {
+	reader, err := newFileReader(s.fname)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var (
+		batch       []*fileEntry
+		batchSize   int
+		batchOffset int64
+	)
+
+	cut := func(offset int64) error {
+		if len(batch) < batchSize {
+			offset = batchOffset
+		}
+		if err := os.Truncate(s.fname, offset); err != nil {
+			return fmt.Errorf("file: cannot cut off incomplete record: %w", err)
+		}
+		return nil
+	}
+
+	for {
+		offset := reader.decoder.InputOffset()
+
+		entry, err := reader.Read()
+		switch {
+		case errors.Is(err, io.EOF):
+			if len(batch) < batchSize {
+				return cut(offset)
+			}
+			return nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return cut(offset)
+		case err != nil:
+			return fmt.Errorf("file: cannot replay log: %w", err)
+		}
+
+		if entry.Op == recordBatch {
+			batch, batchSize, batchOffset = nil, entry.Batch, offset
+			// The header is superseded by the records of the batch.
+			s.dead++
+			continue
+		}
+
+		if len(batch) < batchSize {
+			batch = append(batch, entry)
+			if len(batch) < batchSize {
+				continue
+			}
+			for _, e := range batch {
+				s.apply(e)
+			}
+			batch, batchSize = nil, 0
+			continue
+		}
+
+		s.apply(entry)
+	}
+}
+
+// apply updates indexes with the record.
+func (s *FileStorage) apply(e *fileEntry) {
+	switch e.Op {
+	case recordDelete:
+		if cur, ok := s.entries[e.ShortURL]; ok {
+			cur.Deleted = true
+			cur.DeletedAt = e.DeletedAt
+		}
+		s.dead++
+	case recordRestore:
+		if cur, ok := s.entries[e.ShortURL]; ok {
+			cur.Deleted = false
+			cur.DeletedAt = nil
+		}
+		s.dead++
+	case recordClick:
+		if cur, ok := s.entries[e.ShortURL]; ok {
+			// Records written before clicks were counted in memory stand for a single click.
+			if e.Clicks > 0 {
+				cur.Clicks += e.Clicks
+			} else {
+				cur.Clicks++
+			}
+		}
+		s.dead++
+	case recordPurge:
+		if cur, ok := s.entries[e.ShortURL]; ok {
+			delete(s.entries, e.ShortURL)
+			delete(s.pendingClicks, e.ShortURL)
+			delete(s.byOriginal, cur.OriginalURL)
+			s.byUser[cur.CreatedBy] = removeString(s.byUser[cur.CreatedBy], e.ShortURL)
+			// The last state of the entry is superseded too.
+			s.dead++
+		}
+		s.dead++
+	default:
+		if prev, ok := s.entries[e.ShortURL]; ok {
+			delete(s.byOriginal, prev.OriginalURL)
+			s.dead++
+		} else {
+			s.byUser[e.CreatedBy] = append(s.byUser[e.CreatedBy], e.ShortURL)
+		}
+
+		s.entries[e.ShortURL] = e
+		s.byOriginal[e.OriginalURL] = e.ShortURL
+		// The state already holds the clicks that were not written.
+		delete(s.pendingClicks, e.ShortURL)
+	}
+}
+
+func removeString(list []string, v string) []string {
+	for i, s := range list {
+		if s == v {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+// append writes the records to the log and applies them to indexes.
+// Several records are preceded by a batch header, so replay applies either all of them or none.
+// Must be called with the write lock held.
+func (s *FileStorage) append(entries ...*fileEntry) error {
+	rows := make([]any, 0, len(entries)+1)
+	if len(entries) > 1 {
+		rows = append(rows, &fileEntry{Op: recordBatch, Batch: len(entries)})
+	}
+	for _, e := range entries {
+		rows = append(rows, e)
+	}
+
+	if err := s.writer.WriteAll(rows...); err != nil {
+		return fmt.Errorf("file: cannot write records: %w", err)
+	}
+
+	if len(entries) > 1 {
+		s.dead++
+	}
+	for _, e := range entries {
+		s.apply(e)
+	}
+
+	return nil
 }
 
 // Add adds new entry to the file.
 func (s *FileStorage) Add(ctx context.Context, u URLEntry, userID string) error {
-	return s.writer.Write(fileEntry{
-		UUID:        s.idGen.Next(),
-		ShortURL:    u.ShortURL,
-		OriginalURL: u.OriginalURL,
-		CreatedBy:   userID,
-	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(u); err != nil {
+		return err
+	}
+
+	return s.append(s.newEntry(u, userID))
 }
 
 // AddMany adds new entries to the file.
+// Nothing is added if any of the entries is not unique.
 func (s *FileStorage) AddMany(ctx context.Context, urls []URLEntry, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shorts := make(map[string]struct{}, len(urls))
+	origs := make(map[string]struct{}, len(urls))
+
 	for _, u := range urls {
-		s.Add(ctx, u, userID)
+		if err := s.checkUnique(u); err != nil {
+			return err
+		}
+
+		if _, ok := origs[u.OriginalURL]; ok {
+			return ErrNotUnique
+		}
+		if _, ok := shorts[u.ShortURL]; ok {
+			return ErrShortURLTaken
+		}
+
+		origs[u.OriginalURL] = struct{}{}
+		shorts[u.ShortURL] = struct{}{}
+	}
+
+	entries := make([]*fileEntry, len(urls))
+	for i, u := range urls {
+		entries[i] = s.newEntry(u, userID)
+	}
+
+	return s.append(entries...)
+}
+
+// AddOrGetMany adds entries with new original urls and finds existing ones for the rest.
+// Nothing is added if a short url is taken.
+func (s *FileStorage) AddOrGetMany(ctx context.Context, urls []URLEntry, userID string) ([]AddResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]AddResult, len(urls))
+	var added []*fileEntry
+	batch := make(map[string]string, len(urls))
+	shorts := make(map[string]struct{}, len(urls))
+
+	for i, u := range urls {
+		if short, ok := s.byOriginal[u.OriginalURL]; ok {
+			results[i] = AddResult{ShortURL: short, Existed: true}
+			continue
+		}
+		if short, ok := batch[u.OriginalURL]; ok {
+			results[i] = AddResult{ShortURL: short, Existed: true}
+			continue
+		}
+
+		if _, ok := s.entries[u.ShortURL]; ok {
+			return nil, ErrShortURLTaken
+		}
+		if _, ok := shorts[u.ShortURL]; ok {
+			return nil, ErrShortURLTaken
+		}
+
+		shorts[u.ShortURL] = struct{}{}
+		batch[u.OriginalURL] = u.ShortURL
+		added = append(added, s.newEntry(u, userID))
+		results[i] = AddResult{ShortURL: u.ShortURL}
+	}
+
+	if len(added) > 0 {
+		if err := s.append(added...); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+func (s *FileStorage) checkUnique(u URLEntry) error {
+	if _, ok := s.byOriginal[u.OriginalURL]; ok {
+		return ErrNotUnique
+	}
+
+	if _, ok := s.entries[u.ShortURL]; ok {
+		return ErrShortURLTaken
 	}
 
 	return nil
 }
 
+func (s *FileStorage) newEntry(u URLEntry, userID string) *fileEntry {
+	return &fileEntry{
+		UUID:        s.idGen.Next(),
+		ShortURL:    u.ShortURL,
+		OriginalURL: u.OriginalURL,
+		CreatedBy:   userID,
+		CreatedAt:   creationTime(),
+		Deleted:     u.Deleted,
+		DeletedAt:   u.DeletedAt,
+		Alias:       u.Alias,
+		ExpiresAt:   u.ExpiresAt,
+		MaxClicks:   u.MaxClicks,
+		Clicks:      u.Clicks,
+	}
+}
+
 // GetByShort retrieves a file entry by short url.
 func (s *FileStorage) GetByShort(ctx context.Context, shortURL string) (*URLEntry, error) {
-	reader, err := newFileReader(s.fname)
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	defer reader.Close()
 
-	entry, err := reader.find(func(entry *fileEntry) bool { return entry.ShortURL == shortURL })
-	if err != nil {
-		return nil, err
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[shortURL]
+	if !ok {
+		return nil, ErrNotFound
 	}
 
-	return &URLEntry{ShortURL: entry.ShortURL, OriginalURL: entry.OriginalURL, Deleted: entry.Deleted}, nil
+	return entry.toURLEntry(), nil
 }
 
 // GetByOriginal retrieves a file entry by original url.
 func (s *FileStorage) GetByOriginal(ctx context.Context, origURL string) (*URLEntry, error) {
-	reader, err := newFileReader(s.fname)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	short, ok := s.byOriginal[origURL]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return s.entries[short].toURLEntry(), nil
+}
+
+// GetURLsCreatedBy retrieves file entries added by user matching the filter.
+func (s *FileStorage) GetURLsCreatedBy(ctx context.Context, userID string, filter URLFilter) ([]URLEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var urls []URLEntry
+	for _, short := range s.byUser[userID] {
+		if entry, ok := s.entries[short]; ok && entry.CreatedBy == userID {
+			urls = append(urls, *entry.toURLEntry())
+		}
+	}
+
+	return applyURLFilter(urls, filter), nil
+}
+
+// MarkDeleted appends tombstones for given urls owned by the users and returns the owned ones.
+// Tombstones of the batch are written at once.
+func (s *FileStorage) MarkDeleted(ctx context.Context, urls ...EntryToDelete) ([]EntryToDelete, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var owned []EntryToDelete
+	var tombstones []*fileEntry
+	marked := make(map[string]struct{}, len(urls))
+	now := time.Now().UTC()
+
+	for _, u := range urls {
+		entry, ok := s.entries[u.ShortURL]
+		if !ok || entry.CreatedBy != u.UserID {
+			continue
+		}
+
+		owned = append(owned, u)
+		if entry.Deleted {
+			continue
+		}
+
+		if _, ok := marked[u.ShortURL]; ok {
+			continue
+		}
+		marked[u.ShortURL] = struct{}{}
+
+		tombstones = append(tombstones, &fileEntry{Op: recordDelete, ShortURL: u.ShortURL, DeletedAt: &now})
+	}
+
+	if len(tombstones) == 0 {
+		return owned, nil
+	}
+
+	if err := s.append(tombstones...); err != nil {
+		return nil, err
+	}
+
+	return owned, nil
+}
+
+// RestoreDeleted appends restore records for given deleted urls owned by the users.
+func (s *FileStorage) RestoreDeleted(ctx context.Context, urls ...EntryToDelete) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []*fileEntry
+	restored := make(map[string]struct{}, len(urls))
+
+	for _, u := range urls {
+		entry, ok := s.entries[u.ShortURL]
+		if !ok || entry.CreatedBy != u.UserID || !entry.Deleted {
+			continue
+		}
+
+		if _, ok := restored[u.ShortURL]; ok {
+			continue
+		}
+		restored[u.ShortURL] = struct{}{}
+
+		records = append(records, &fileEntry{Op: recordRestore, ShortURL: u.ShortURL})
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	return s.append(records...)
+}
+
+// UpdateOriginal appends new state of the entry with changed original url
+// if it is created by the user and not deleted.
+func (s *FileStorage) UpdateOriginal(ctx context.Context, shortURL string, userID string, origURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[shortURL]
+	if !ok || entry.CreatedBy != userID || entry.Deleted {
+		return ErrNotFound
+	}
+
+	if short, ok := s.byOriginal[origURL]; ok {
+		if short == shortURL {
+			return nil
+		}
+		return ErrNotUnique
+	}
+
+	updated := *entry
+	updated.OriginalURL = origURL
+
+	return s.append(&updated)
+}
+
+// PurgeDeleted appends purge records for at most limit entries deleted before deletedBefore.
+// It returns short urls of the removed entries.
+// Click events of the entries are dropped by purge records in the clicks file,
+// which are written first, so a code is never freed with its clicks still counted.
+func (s *FileStorage) PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged []string
+	var records []*fileEntry
+
+	for short, e := range s.entries {
+		if len(purged) == limit {
+			break
+		}
+
+		if e.Deleted && e.DeletedAt != nil && e.DeletedAt.Before(deletedBefore) {
+			purged = append(purged, short)
+			records = append(records, &fileEntry{Op: recordPurge, ShortURL: short})
+		}
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	if err := s.purgeClicks(purged); err != nil {
+		return nil, err
+	}
+
+	if err := s.append(records...); err != nil {
+		return nil, err
+	}
+
+	return purged, nil
+}
+
+// purgeClicks appends records dropping click events of the short urls.
+func (s *FileStorage) purgeClicks(shorts []string) error {
+	s.clicksMu.Lock()
+	defer s.clicksMu.Unlock()
+
+	rows := make([]any, len(shorts))
+	for i, short := range shorts {
+		rows[i] = fileClickEvent{Op: recordPurge, ShortURL: short}
+	}
+
+	if err := s.clicksWriter.WriteAll(rows...); err != nil {
+		return fmt.Errorf("file: cannot write click records: %w", err)
+	}
+
+	for _, short := range shorts {
+		s.dropClicks(short)
+	}
+
+	return nil
+}
+
+// dropClicks accounts a purge record of the short url in the clicks file.
+// Must be called with clicksMu held.
+func (s *FileStorage) dropClicks(short string) {
+	var n int
+	if c, ok := s.clickStats[short]; ok {
+		n = int(c.total)
+	}
+	delete(s.clickStats, short)
+	s.clicksDropped[short] += n
+	s.clicksLive -= n
+	s.clicksDead += n + 1
+}
+
+// CountClick increases the click counter of the entry unless its click limit is reached.
+// The click is written to the log later, together with other clicks of the entry.
+func (s *FileStorage) CountClick(ctx context.Context, shortURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if entry.MaxClicks > 0 && entry.Clicks >= entry.MaxClicks {
+		return ErrClickLimitReached
+	}
+
+	entry.Clicks++
+	s.pendingClicks[shortURL]++
+
+	return nil
+}
+
+// flushClicks writes a click record for every entry with pending clicks.
+// Must be called with the write lock held.
+func (s *FileStorage) flushClicks() error {
+	if len(s.pendingClicks) == 0 {
+		return nil
+	}
+
+	rows := make([]any, 0, len(s.pendingClicks))
+	for short, n := range s.pendingClicks {
+		rows = append(rows, &fileEntry{Op: recordClick, ShortURL: short, Clicks: n})
+	}
+
+	// Entries already hold the clicks, so the records are not applied.
+	if err := s.writer.WriteAll(rows...); err != nil {
+		return fmt.Errorf("file: cannot write click records: %w", err)
+	}
+
+	s.dead += len(rows)
+	s.pendingClicks = make(map[string]int64)
+
+	return nil
+}
+
+// Compact rewrites the log keeping only the current state of each entry
+// and the clicks file keeping only events not dropped by purge records.
+// New files are written to temporary files which then replace the old ones.
+func (s *FileStorage) Compact() error {
+	s.mu.Lock()
+	err := s.compact()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.clicksMu.Lock()
+	defer s.clicksMu.Unlock()
+
+	return s.compactClicks()
+}
+
+// createTemp creates a temporary file next to fname with the permissions of fname.
+func createTemp(fname string) (*os.File, error) {
+	info, err := os.Stat(fname)
 	if err != nil {
 		return nil, err
 	}
-	defer reader.Close()
 
-	entry, err := reader.find(func(entry *fileEntry) bool { return entry.OriginalURL == origURL })
+	tmp, err := os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".compact-*")
 	if err != nil {
 		return nil, err
 	}
 
-	return &URLEntry{ShortURL: entry.ShortURL, OriginalURL: entry.OriginalURL, Deleted: entry.Deleted}, nil
+	// Temporary files are created private, the new file keeps permissions of the old one.
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return tmp, nil
 }
 
-// GetURLsCreatedBy retrieves file entries added by user.
-func (s *FileStorage) GetURLsCreatedBy(ctx context.Context, userID string) ([]URLEntry, error) {
-	reader, err := newFileReader(s.fname)
+func (s *FileStorage) compact() error {
+	tmp, err := createTemp(s.fname)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("file: compaction failed: %w", err)
 	}
-	defer reader.Close()
+	w := &jsonFileWriter{file: tmp, encoder: json.NewEncoder(tmp)}
 
-	var urls []URLEntry
+	fail := func(err error) error {
+		w.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("file: compaction failed: %w", err)
+	}
+
+	for _, shorts := range s.byUser {
+		for _, short := range shorts {
+			if err := w.Write(s.entries[short]); err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+
+	// The temporary file is reused as the writer, so it stays valid after the rename.
+	if err := os.Rename(tmp.Name(), s.fname); err != nil {
+		return fail(err)
+	}
+
+	old := s.writer
+	s.writer = w
+	s.dead = 0
+	// The written states hold the pending clicks.
+	s.pendingClicks = make(map[string]int64)
+
+	return old.Close()
+}
+
+// compactClicks rewrites the clicks file without purge records and events dropped by them.
+// Must be called with clicksMu held.
+func (s *FileStorage) compactClicks() error {
+	tmp, err := createTemp(s.clicksFname)
+	if err != nil {
+		return fmt.Errorf("file: clicks compaction failed: %w", err)
+	}
+	w := &jsonFileWriter{file: tmp, encoder: json.NewEncoder(tmp)}
+
+	fail := func(err error) error {
+		w.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("file: clicks compaction failed: %w", err)
+	}
+
+	file, err := os.Open(s.clicksFname)
+	if err != nil {
+		return fail(err)
+	}
+	defer file.Close()
+
+	// Purge records drop the earliest events of their short urls.
+	skipped := make(map[string]int)
+
+	decoder := json.NewDecoder(file)
 	for {
-		entry, err := reader.Read()
-		if err != nil {
+		var e fileClickEvent
+		if err := decoder.Decode(&e); err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
-			return nil, fmt.Errorf("file: %w", err)
+			return fail(err)
+		}
+
+		if e.Op == recordPurge {
+			continue
+		}
+		if skipped[e.ShortURL] < s.clicksDropped[e.ShortURL] {
+			skipped[e.ShortURL]++
+			continue
 		}
 
-		if entry.CreatedBy == userID {
-			urls = append(urls, URLEntry{ShortURL: entry.ShortURL, OriginalURL: entry.OriginalURL, Deleted: entry.Deleted})
+		if err := w.Write(e); err != nil {
+			return fail(err)
 		}
 	}
 
-	return urls, nil
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.clicksFname); err != nil {
+		return fail(err)
+	}
+
+	old := s.clicksWriter
+	s.clicksWriter = w
+	s.clicksDropped = make(map[string]int)
+	s.clicksDead = 0
+
+	return old.Close()
 }
 
-func (r *jsonFileReader) find(cond func(entry *fileEntry) bool) (*fileEntry, error) {
+func (s *FileStorage) runCompaction() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(compactionPeriod)
+	defer ticker.Stop()
+
+	flush := time.NewTicker(clickFlushPeriod)
+	defer flush.Stop()
+
 	for {
-		entry, err := r.Read()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return nil, ErrNotFound
+		select {
+		case <-s.stop:
+			return
+		case <-flush.C:
+			s.mu.Lock()
+			// Clicks that failed to be written stay pending and are retried later.
+			_ = s.flushClicks()
+			s.mu.Unlock()
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dead >= minDeadRecords && s.dead >= len(s.entries) {
+				// A failed compaction leaves the old log in place, so it is just retried later.
+				_ = s.compact()
 			}
-			return nil, err
-		}
+			s.mu.Unlock()
 
-		if cond(entry) {
-			return entry, nil
+			s.clicksMu.Lock()
+			if s.clicksDead >= minDeadRecords && s.clicksDead >= s.clicksLive {
+				_ = s.compactClicks()
+			}
+			s.clicksMu.Unlock()
 		}
 	}
 }
 
-// MarkDeleted sets deleted flag for given urls.
-func (s *FileStorage) MarkDeleted(ctx context.Context, urls ...EntryToDelete) error {
-	data, err := os.ReadFile(s.fname)
+// replayClicks aggregates events in the clicks file.
+// An event that was not written completely is cut off.
+func (s *FileStorage) replayClicks() error {
+	file, err := os.OpenFile(s.clicksFname, os.O_RDONLY|os.O_CREATE, 0666)
 	if err != nil {
-		return fmt.Errorf("file: cannot read file: %w", err)
+		return fmt.Errorf("storage: failed to open the file %s: %w", s.clicksFname, err)
 	}
+	defer file.Close()
 
-	var entries []fileEntry
-
-	err = json.Unmarshal(data, &entries)
-	if err != nil {
-		return fmt.Errorf("file: cannot unmarshal data: %w", err)
-	}
+	decoder := json.NewDecoder(file)
+	for {
+		offset := decoder.InputOffset()
 
-	find := func(userID, url string) (*fileEntry, bool) {
-		for _, u := range entries {
-			if u.CreatedBy == userID && u.ShortURL == url {
-				return &u, true
+		var e fileClickEvent
+		err := decoder.Decode(&e)
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			if err := os.Truncate(s.clicksFname, offset); err != nil {
+				return fmt.Errorf("file: cannot cut off incomplete click event: %w", err)
 			}
+			return nil
+		case err != nil:
+			return fmt.Errorf("file: cannot replay clicks: %w", err)
 		}
 
-		return nil, false
+		if e.Op == recordPurge {
+			s.dropClicks(e.ShortURL)
+			continue
+		}
+
+		s.addClick(e.toClickEvent())
 	}
+}
 
-	for _, u := range urls {
-		entry, ok := find(u.UserID, u.ShortURL)
-		if ok {
-			entry.Deleted = true
-		}
+// addClick counts the event written to the clicks file.
+// Must be called with clicksMu held.
+func (s *FileStorage) addClick(e ClickEvent) {
+	c, ok := s.clickStats[e.ShortURL]
+	if !ok {
+		c = newClickCounter()
+		s.clickStats[e.ShortURL] = c
 	}
 
-	json, err := json.Marshal(entries)
-	if err != nil {
-		return fmt.Errorf("file: cannot marshal data: %w", err)
+	c.add(e)
+	s.clicksLive++
+}
+
+// AddClickEvents appends click events to the clicks file.
+func (s *FileStorage) AddClickEvents(ctx context.Context, events ...ClickEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	if err := os.WriteFile(s.fname, json, 0666); err != nil {
-		return fmt.Errorf("file: cannot write data: %w", err)
+	s.clicksMu.Lock()
+	defer s.clicksMu.Unlock()
+
+	for _, e := range events {
+		err := s.clicksWriter.Write(fileClickEvent{
+			ShortURL:  e.ShortURL,
+			Time:      e.Time,
+			Referrer:  e.Referrer,
+			UserAgent: e.UserAgent,
+			IPHash:    e.IPHash,
+		})
+		if err != nil {
+			return fmt.Errorf("file: %w", err)
+		}
+
+		s.addClick(e)
 	}
 
 	return nil
 }
 
-// Ping always returns nil.
+// GetClickStats returns aggregated click events of the short url.
+// Events are aggregated in memory as they are written, so the file is not read.
+func (s *FileStorage) GetClickStats(ctx context.Context, shortURL string, topReferrers int) (*ClickStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.clicksMu.Lock()
+	defer s.clicksMu.Unlock()
+
+	c, ok := s.clickStats[shortURL]
+	if !ok {
+		return aggregateClicks(nil, topReferrers), nil
+	}
+
+	return c.stats(topReferrers), nil
+}
+
+// Ping returns nil unless the context is done.
 func (s *FileStorage) Ping(ctx context.Context) error {
-	return nil
+	return ctx.Err()
 }
@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type seqGen struct{ n uint64 }
+
+func (g *seqGen) Next() uint64 {
+	g.n++
+	return g.n
+}
+
+func TestFileStorageReplay(t *testing.T) {
+	ctx := context.TODO()
+	fname := filepath.Join(t.TempDir(), "urls.json")
+
+	s, err := NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru"}, "user"))
+	require.NoError(t, s.AddMany(ctx, []URLEntry{
+		{ShortURL: "b", OriginalURL: "http://b.ru", MaxClicks: 5},
+		{ShortURL: "c", OriginalURL: "http://c.ru"},
+	}, "user"))
+	require.NoError(t, s.CountClick(ctx, "b"))
+	_, err = s.MarkDeleted(ctx,
+		EntryToDelete{ShortURL: "a", UserID: "user"},
+		EntryToDelete{ShortURL: "c", UserID: "another"},
+	)
+	require.NoError(t, err)
+	_, err = s.MarkDeleted(ctx, EntryToDelete{ShortURL: "b", UserID: "user"})
+	require.NoError(t, err)
+	require.NoError(t, s.RestoreDeleted(ctx, EntryToDelete{ShortURL: "b", UserID: "user"}))
+	require.NoError(t, s.UpdateOriginal(ctx, "c", "user", "http://new.ru"))
+	require.NoError(t, s.Close())
+
+	s, err = NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	a, err := s.GetByShort(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, a.Deleted)
+	require.NotNil(t, a.DeletedAt)
+
+	b, err := s.GetByOriginal(ctx, "http://b.ru")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), b.Clicks)
+	require.False(t, b.Deleted)
+
+	c, err := s.GetByOriginal(ctx, "http://new.ru")
+	require.NoError(t, err)
+	require.Equal(t, "c", c.ShortURL)
+	require.False(t, c.Deleted)
+
+	_, err = s.GetByOriginal(ctx, "http://c.ru")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	urls, err := s.GetURLsCreatedBy(ctx, "user", URLFilter{IncludeDeleted: true})
+	require.NoError(t, err)
+	require.Len(t, urls, 3)
+
+	require.ErrorIs(t, s.Add(ctx, URLEntry{ShortURL: "d", OriginalURL: "http://a.ru"}, "user"), ErrNotUnique)
+	require.ErrorIs(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://d.ru"}, "user"), ErrShortURLTaken)
+}
+
+func TestFileStorageCompact(t *testing.T) {
+	ctx := context.TODO()
+	fname := filepath.Join(t.TempDir(), "urls.json")
+
+	s, err := NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://a.ru", MaxClicks: 10}, "user"))
+	require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "b", OriginalURL: "http://b.ru"}, "user"))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.CountClick(ctx, "a"))
+	}
+	_, err = s.MarkDeleted(ctx, EntryToDelete{ShortURL: "b", UserID: "user"})
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chmod(fname, 0640))
+	require.NoError(t, s.Compact())
+
+	data, err := os.ReadFile(fname)
+	require.NoError(t, err)
+	require.Equal(t, 2, bytes.Count(data, []byte("\n")))
+
+	info, err := os.Stat(fname)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	// Writes after compaction go to the new log.
+	require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "c", OriginalURL: "http://c.ru"}, "user"))
+	require.NoError(t, s.Close())
+
+	s, err = NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	a, err := s.GetByShort(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), a.Clicks)
+
+	b, err := s.GetByShort(ctx, "b")
+	require.NoError(t, err)
+	require.True(t, b.Deleted)
+
+	_, err = s.GetByShort(ctx, "c")
+	require.NoError(t, err)
+}
+
+func TestFileStoragePurge(t *testing.T) {
+	ctx := context.TODO()
+	fname := filepath.Join(t.TempDir(), "urls.json")
+
+	s, err := NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+
+	require.NoError(t, s.AddMany(ctx, []URLEntry{
+		{ShortURL: "a", OriginalURL: "http://a.ru"},
+		{ShortURL: "b", OriginalURL: "http://b.ru"},
+	}, "user"))
+	_, err = s.MarkDeleted(ctx, EntryToDelete{ShortURL: "a", UserID: "user"})
+	require.NoError(t, err)
+
+	purged, err := s.PurgeDeleted(ctx, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, purged)
+	require.NoError(t, s.Close())
+
+	for _, compact := range []bool{false, true} {
+		s, err = NewFileStorage(fname, &seqGen{})
+		require.NoError(t, err)
+
+		_, err = s.GetByShort(ctx, "a")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		urls, err := s.GetURLsCreatedBy(ctx, "user", URLFilter{IncludeDeleted: true})
+		require.NoError(t, err)
+		require.Equal(t, []string{"b"}, shortURLs(urls))
+
+		if compact {
+			require.NoError(t, s.Compact())
+
+			data, err := os.ReadFile(fname)
+			require.NoError(t, err)
+			require.Equal(t, 1, bytes.Count(data, []byte("\n")))
+		}
+
+		require.NoError(t, s.Close())
+	}
+}
+
+func TestFileStorageCountClick(t *testing.T) {
+	ctx := context.TODO()
+	fname := filepath.Join(t.TempDir(), "urls.json")
+
+	// A click record written before clicks were counted in memory stands for a single click.
+	data := `{"uuid":1,"short_url":"a","original_url":"http://a.ru","created_by":"user","max_clicks":5}` + "\n" +
+		`{"op":"click","short_url":"a"}` + "\n"
+	require.NoError(t, os.WriteFile(fname, []byte(data), 0666))
+
+	s, err := NewFileStorage(fname, &seqGen{n: 1})
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.CountClick(ctx, "a"))
+	}
+	require.ErrorIs(t, s.CountClick(ctx, "a"), ErrClickLimitReached)
+
+	// Clicks are not written until they are flushed.
+	written, err := os.ReadFile(fname)
+	require.NoError(t, err)
+	require.Equal(t, data, string(written))
+
+	require.NoError(t, s.Close())
+
+	written, err = os.ReadFile(fname)
+	require.NoError(t, err)
+	require.Equal(t, 3, bytes.Count(written, []byte("\n")))
+
+	s, err = NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	a, err := s.GetByShort(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), a.Clicks)
+}
+
+func TestFileStorageCompactClicks(t *testing.T) {
+	ctx := context.TODO()
+	fname := filepath.Join(t.TempDir(), "urls.json")
+
+	s, err := NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+
+	require.NoError(t, s.AddMany(ctx, []URLEntry{
+		{ShortURL: "a", OriginalURL: "http://a.ru"},
+		{ShortURL: "b", OriginalURL: "http://b.ru"},
+	}, "user"))
+	now := time.Now()
+	require.NoError(t, s.AddClickEvents(ctx,
+		ClickEvent{ShortURL: "a", Time: now},
+		ClickEvent{ShortURL: "a", Time: now},
+		ClickEvent{ShortURL: "b", Time: now},
+	))
+
+	_, err = s.MarkDeleted(ctx, EntryToDelete{ShortURL: "a", UserID: "user"})
+	require.NoError(t, err)
+	_, err = s.PurgeDeleted(ctx, now.Add(time.Hour), 10)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// The code is reused after purge, its new events are kept.
+	require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "a", OriginalURL: "http://new.ru"}, "user"))
+	require.NoError(t, s.AddClickEvents(ctx, ClickEvent{ShortURL: "a", Time: now}))
+
+	require.NoError(t, s.Compact())
+
+	data, err := os.ReadFile(fname + ".clicks")
+	require.NoError(t, err)
+	require.Equal(t, 2, bytes.Count(data, []byte("\n")))
+
+	for short, want := range map[string]int64{"a": 1, "b": 1} {
+		stats, err := s.GetClickStats(ctx, short, 5)
+		require.NoError(t, err)
+		require.Equal(t, want, stats.Total)
+	}
+
+	// Events after compaction go to the new file.
+	require.NoError(t, s.AddClickEvents(ctx, ClickEvent{ShortURL: "b", Time: now}))
+	stats, err := s.GetClickStats(ctx, "b", 5)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.Total)
+}
+
+func TestFileStorageIncompleteRecord(t *testing.T) {
+	ctx := context.TODO()
+	fname := filepath.Join(t.TempDir(), "urls.json")
+
+	data := `{"uuid":1,"short_url":"a","original_url":"http://a.ru","created_by":"user"}` + "\n" +
+		`{"uuid":2,"short_url":"b","orig`
+	require.NoError(t, os.WriteFile(fname, []byte(data), 0666))
+
+	s, err := NewFileStorage(fname, &seqGen{n: 2})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Add(ctx, URLEntry{ShortURL: "b", OriginalURL: "http://b.ru"}, "user"))
+	require.NoError(t, s.Close())
+
+	s, err = NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	urls, err := s.GetURLsCreatedBy(ctx, "user", URLFilter{IncludeDeleted: true})
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+}
+
+func TestFileStorageIncompleteBatch(t *testing.T) {
+	ctx := context.TODO()
+	fname := filepath.Join(t.TempDir(), "urls.json")
+
+	// The batch header promises three records but only two of them were written.
+	data := `{"uuid":1,"short_url":"a","original_url":"http://a.ru","created_by":"user"}` + "\n" +
+		`{"op":"batch","batch":3}` + "\n" +
+		`{"uuid":2,"short_url":"b","original_url":"http://b.ru","created_by":"user"}` + "\n" +
+		`{"op":"delete","short_url":"a"}` + "\n"
+	require.NoError(t, os.WriteFile(fname, []byte(data), 0666))
+
+	s, err := NewFileStorage(fname, &seqGen{n: 2})
+	require.NoError(t, err)
+
+	a, err := s.GetByShort(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, a.Deleted)
+
+	_, err = s.GetByShort(ctx, "b")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, s.AddMany(ctx, []URLEntry{
+		{ShortURL: "b", OriginalURL: "http://b.ru"},
+		{ShortURL: "c", OriginalURL: "http://c.ru"},
+	}, "user"))
+	require.NoError(t, s.Close())
+
+	s, err = NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	urls, err := s.GetURLsCreatedBy(ctx, "user", URLFilter{IncludeDeleted: true})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a", "b", "c"}, shortURLs(urls))
+}
+
+func TestFileStorageClickStatsReplay(t *testing.T) {
+	ctx := context.TODO()
+	fname := filepath.Join(t.TempDir(), "urls.json")
+
+	s, err := NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.AddClickEvents(ctx,
+		ClickEvent{ShortURL: "a", Time: day, Referrer: "http://ref.ru"},
+		ClickEvent{ShortURL: "a", Time: day.Add(24 * time.Hour)},
+	))
+	require.NoError(t, s.Close())
+
+	// An event that was not written completely before a crash.
+	f, err := os.OpenFile(fname+".clicks", os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"short_url":"a","ti`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+	require.NoError(t, s.AddClickEvents(ctx, ClickEvent{ShortURL: "a", Time: day}))
+	require.NoError(t, s.Close())
+
+	s, err = NewFileStorage(fname, &seqGen{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	stats, err := s.GetClickStats(ctx, "a", 5)
+	require.NoError(t, err)
+	require.Equal(t, &ClickStats{
+		Total: 3,
+		Daily: []DailyClicks{
+			{Day: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Clicks: 2},
+			{Day: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), Clicks: 1},
+		},
+		TopReferrers: []ReferrerClicks{{Referrer: "http://ref.ru", Clicks: 1}},
+	}, stats)
+}
+
+func TestFileStorageCloseTwice(t *testing.T) {
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"), &seqGen{})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Close())
+	require.NoError(t, s.Close())
+}
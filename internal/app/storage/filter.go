@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Position in the list of user urls, which is ordered by creation time and short url.
+type URLCursor struct {
+	CreatedAt time.Time
+	ShortURL  string
+}
+
+// Filter and page of user urls.
+type URLFilter struct {
+	// Maximum number of entries, all entries if not positive.
+	Limit int
+	// Only entries following the cursor if set.
+	After *URLCursor
+	// Only entries created after or before the time if set.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Only entries with original url containing the substring, case-insensitive.
+	Search string
+	// Deleted entries are skipped unless it is set.
+	IncludeDeleted bool
+}
+
+func (f URLFilter) match(u *URLEntry) bool {
+	switch {
+	case u.Deleted && !f.IncludeDeleted:
+		return false
+	case f.After != nil && !cursorLess(*f.After, u.CreatedAt, u.ShortURL):
+		return false
+	case !f.CreatedAfter.IsZero() && !u.CreatedAt.After(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !u.CreatedAt.Before(f.CreatedBefore):
+		return false
+	case f.Search != "" && !strings.Contains(strings.ToLower(u.OriginalURL), strings.ToLower(f.Search)):
+		return false
+	}
+
+	return true
+}
+
+// cursorLess reports whether the cursor goes before the entry with the creation time and short url.
+func cursorLess(c URLCursor, createdAt time.Time, shortURL string) bool {
+	if !c.CreatedAt.Equal(createdAt) {
+		return c.CreatedAt.Before(createdAt)
+	}
+	return c.ShortURL < shortURL
+}
+
+// applyURLFilter returns ordered page of urls matching the filter.
+// It is used by storages that keep entries of a user unordered.
+func applyURLFilter(urls []URLEntry, f URLFilter) []URLEntry {
+	res := make([]URLEntry, 0, len(urls))
+	for i := range urls {
+		if f.match(&urls[i]) {
+			res = append(res, urls[i])
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return cursorLess(URLCursor{CreatedAt: res[i].CreatedAt, ShortURL: res[i].ShortURL},
+			res[j].CreatedAt, res[j].ShortURL)
+	})
+
+	if f.Limit > 0 && len(res) > f.Limit {
+		res = res[:f.Limit]
+	}
+
+	return res
+}
+
+// creationTime returns current time with precision kept by all storages.
+func creationTime() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
@@ -3,111 +3,384 @@ package storage
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 // In-memory storage.
-type InMemoryStorage map[string]inMemoryEntry
+type InMemoryStorage struct {
+	mu          sync.RWMutex
+	entries     map[string]inMemoryEntry
+	clickEvents map[string][]ClickEvent
+}
 
 type inMemoryEntry struct {
 	OriginalURL string
 	CreatedBy   string
+	CreatedAt   time.Time
 	Deleted     bool
+	DeletedAt   *time.Time
+	Alias       bool
+	ExpiresAt   *time.Time
+	MaxClicks   int64
+	Clicks      int64
+}
+
+func newInMemoryEntry(u URLEntry, userID string) inMemoryEntry {
+	return inMemoryEntry{
+		OriginalURL: u.OriginalURL,
+		CreatedBy:   userID,
+		CreatedAt:   creationTime(),
+		Deleted:     u.Deleted,
+		DeletedAt:   u.DeletedAt,
+		Alias:       u.Alias,
+		ExpiresAt:   u.ExpiresAt,
+		MaxClicks:   u.MaxClicks,
+		Clicks:      u.Clicks,
+	}
 }
 
-var storage InMemoryStorage
-var lock *sync.RWMutex
+func (e inMemoryEntry) toURLEntry(shortURL string) *URLEntry {
+	return &URLEntry{
+		ShortURL:    shortURL,
+		OriginalURL: e.OriginalURL,
+		Deleted:     e.Deleted,
+		DeletedAt:   e.DeletedAt,
+		Alias:       e.Alias,
+		CreatedBy:   e.CreatedBy,
+		CreatedAt:   e.CreatedAt,
+		ExpiresAt:   e.ExpiresAt,
+		MaxClicks:   e.MaxClicks,
+		Clicks:      e.Clicks,
+	}
+}
 
 // NewInMemory creates new in-memory storage.
-func NewInMemory() InMemoryStorage {
-	storage = make(map[string]inMemoryEntry)
-	lock = &sync.RWMutex{}
-	return storage
+func NewInMemory() *InMemoryStorage {
+	return &InMemoryStorage{
+		entries:     make(map[string]inMemoryEntry),
+		clickEvents: make(map[string][]ClickEvent),
+	}
 }
 
 // Add adds new entry.
-func (s InMemoryStorage) Add(ctx context.Context, u URLEntry, userID string) error {
-	lock.Lock()
-	defer lock.Unlock()
+func (s *InMemoryStorage) Add(ctx context.Context, u URLEntry, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	for _, v := range storage {
-		if v.OriginalURL == u.OriginalURL {
-			return ErrNotUnique
-		}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(u); err != nil {
+		return err
 	}
 
-	storage[u.ShortURL] = inMemoryEntry{OriginalURL: u.OriginalURL, CreatedBy: userID}
+	s.entries[u.ShortURL] = newInMemoryEntry(u, userID)
 
 	return nil
 }
 
 // AddMany adds several entries.
-func (s InMemoryStorage) AddMany(ctx context.Context, urls []URLEntry, userID string) error {
-	lock.Lock()
+// Nothing is added if any of the entries is not unique.
+func (s *InMemoryStorage) AddMany(ctx context.Context, urls []URLEntry, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shorts := make(map[string]struct{}, len(urls))
+	origs := make(map[string]struct{}, len(urls))
+
 	for _, u := range urls {
-		storage[u.ShortURL] = inMemoryEntry{OriginalURL: u.OriginalURL, CreatedBy: userID, Deleted: u.Deleted}
+		if err := s.checkUnique(u); err != nil {
+			return err
+		}
+
+		if _, ok := origs[u.OriginalURL]; ok {
+			return ErrNotUnique
+		}
+		if _, ok := shorts[u.ShortURL]; ok {
+			return ErrShortURLTaken
+		}
+
+		origs[u.OriginalURL] = struct{}{}
+		shorts[u.ShortURL] = struct{}{}
+	}
+
+	for _, u := range urls {
+		s.entries[u.ShortURL] = newInMemoryEntry(u, userID)
+	}
+
+	return nil
+}
+
+// AddOrGetMany adds entries with new original urls and finds existing ones for the rest.
+// Nothing is added if a short url is taken.
+func (s *InMemoryStorage) AddOrGetMany(ctx context.Context, urls []URLEntry, userID string) ([]AddResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := make(map[string]string)
+	for k, v := range s.entries {
+		existing[v.OriginalURL] = k
+	}
+
+	results := make([]AddResult, len(urls))
+	var added []URLEntry
+	shorts := make(map[string]struct{}, len(urls))
+
+	for i, u := range urls {
+		if short, ok := existing[u.OriginalURL]; ok {
+			results[i] = AddResult{ShortURL: short, Existed: true}
+			continue
+		}
+
+		if _, ok := s.entries[u.ShortURL]; ok {
+			return nil, ErrShortURLTaken
+		}
+		if _, ok := shorts[u.ShortURL]; ok {
+			return nil, ErrShortURLTaken
+		}
+
+		shorts[u.ShortURL] = struct{}{}
+		existing[u.OriginalURL] = u.ShortURL
+		added = append(added, u)
+		results[i] = AddResult{ShortURL: u.ShortURL}
+	}
+
+	for _, u := range added {
+		s.entries[u.ShortURL] = newInMemoryEntry(u, userID)
+	}
+
+	return results, nil
+}
+
+func (s *InMemoryStorage) checkUnique(u URLEntry) error {
+	for _, v := range s.entries {
+		if v.OriginalURL == u.OriginalURL {
+			return ErrNotUnique
+		}
+	}
+
+	if _, ok := s.entries[u.ShortURL]; ok {
+		return ErrShortURLTaken
 	}
-	lock.Unlock()
 
 	return nil
 }
 
 // GetByShort retrieves entry by short url.
-func (s InMemoryStorage) GetByShort(ctx context.Context, shortURL string) (*URLEntry, error) {
-	lock.RLock()
-	v, ok := storage[shortURL]
-	lock.RUnlock()
+func (s *InMemoryStorage) GetByShort(ctx context.Context, shortURL string) (*URLEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	v, ok := s.entries[shortURL]
+	s.mu.RUnlock()
 
 	if !ok {
 		return nil, ErrNotFound
 	}
 
-	return &URLEntry{ShortURL: shortURL, OriginalURL: v.OriginalURL, Deleted: v.Deleted}, nil
+	return v.toURLEntry(shortURL), nil
 }
 
 // GetByOriginal retrieves entry by original url.
-func (s InMemoryStorage) GetByOriginal(ctx context.Context, origURL string) (*URLEntry, error) {
-	lock.RLock()
-	for k, v := range storage {
+func (s *InMemoryStorage) GetByOriginal(ctx context.Context, origURL string) (*URLEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for k, v := range s.entries {
 		if v.OriginalURL == origURL {
-			return &URLEntry{ShortURL: k, OriginalURL: origURL, Deleted: v.Deleted}, nil
+			return v.toURLEntry(k), nil
 		}
 	}
-	lock.RUnlock()
 
 	return nil, ErrNotFound
 }
 
-// GetURLsCreatedBy retrieves urls addes by user.
-func (s InMemoryStorage) GetURLsCreatedBy(ctx context.Context, userID string) ([]URLEntry, error) {
+// GetURLsCreatedBy retrieves urls addes by user matching the filter.
+func (s *InMemoryStorage) GetURLsCreatedBy(ctx context.Context, userID string, filter URLFilter) ([]URLEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	var urls []URLEntry
 
-	lock.RLock()
-	for k, v := range storage {
+	s.mu.RLock()
+	for k, v := range s.entries {
 		if v.CreatedBy == userID {
-			urls = append(urls, URLEntry{ShortURL: k, OriginalURL: v.OriginalURL, Deleted: v.Deleted})
+			urls = append(urls, *v.toURLEntry(k))
 		}
 	}
-	lock.RUnlock()
+	s.mu.RUnlock()
 
-	return urls, nil
+	return applyURLFilter(urls, filter), nil
 }
 
-// MarkDeleted sets deleted flag for given urls.
-func (s InMemoryStorage) MarkDeleted(ctx context.Context, urls ...EntryToDelete) error {
-	lock.Lock()
+// MarkDeleted sets deleted flag for given urls and returns the owned ones.
+func (s *InMemoryStorage) MarkDeleted(ctx context.Context, urls ...EntryToDelete) ([]EntryToDelete, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var owned []EntryToDelete
+
+	s.mu.Lock()
 	for _, u := range urls {
-		entry, ok := s[u.ShortURL]
-		if ok && entry.CreatedBy == u.UserID {
+		entry, ok := s.entries[u.ShortURL]
+		if !ok || entry.CreatedBy != u.UserID {
+			continue
+		}
+
+		owned = append(owned, u)
+		if !entry.Deleted {
 			entry.Deleted = true
-			s[u.ShortURL] = entry
+			entry.DeletedAt = &now
+			s.entries[u.ShortURL] = entry
 		}
 	}
-	lock.Unlock()
+	s.mu.Unlock()
+
+	return owned, nil
+}
+
+// RestoreDeleted clears deleted flag for given urls.
+func (s *InMemoryStorage) RestoreDeleted(ctx context.Context, urls ...EntryToDelete) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for _, u := range urls {
+		entry, ok := s.entries[u.ShortURL]
+		if ok && entry.CreatedBy == u.UserID && entry.Deleted {
+			entry.Deleted = false
+			entry.DeletedAt = nil
+			s.entries[u.ShortURL] = entry
+		}
+	}
+	s.mu.Unlock()
 
 	return nil
 }
 
-// Ping return nil.
-func (s InMemoryStorage) Ping(ctx context.Context) error {
+// UpdateOriginal changes original url of the entry if it is created by the user and not deleted.
+func (s *InMemoryStorage) UpdateOriginal(ctx context.Context, shortURL string, userID string, origURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[shortURL]
+	if !ok || entry.CreatedBy != userID || entry.Deleted {
+		return ErrNotFound
+	}
+
+	for k, v := range s.entries {
+		if k != shortURL && v.OriginalURL == origURL {
+			return ErrNotUnique
+		}
+	}
+
+	entry.OriginalURL = origURL
+	s.entries[shortURL] = entry
+
+	return nil
+}
+
+// PurgeDeleted removes at most limit entries deleted before deletedBefore with their click events.
+// It returns short urls of the removed entries.
+func (s *InMemoryStorage) PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged []string
+	for k, v := range s.entries {
+		if len(purged) == limit {
+			break
+		}
+
+		if v.Deleted && v.DeletedAt != nil && v.DeletedAt.Before(deletedBefore) {
+			delete(s.entries, k)
+			delete(s.clickEvents, k)
+			purged = append(purged, k)
+		}
+	}
+
+	return purged, nil
+}
+
+// CountClick increases the click counter of the entry unless its click limit is reached.
+func (s *InMemoryStorage) CountClick(ctx context.Context, shortURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if entry.MaxClicks > 0 && entry.Clicks >= entry.MaxClicks {
+		return ErrClickLimitReached
+	}
+
+	entry.Clicks++
+	s.entries[shortURL] = entry
+
 	return nil
 }
+
+// AddClickEvents saves click events.
+func (s *InMemoryStorage) AddClickEvents(ctx context.Context, events ...ClickEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for _, e := range events {
+		s.clickEvents[e.ShortURL] = append(s.clickEvents[e.ShortURL], e)
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// GetClickStats returns aggregated click events of the short url.
+func (s *InMemoryStorage) GetClickStats(ctx context.Context, shortURL string, topReferrers int) (*ClickStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return aggregateClicks(s.clickEvents[shortURL], topReferrers), nil
+}
+
+// Ping return nil.
+func (s *InMemoryStorage) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
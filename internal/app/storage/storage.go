@@ -3,16 +3,26 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 // Storage.
 type Storage interface {
 	Add(ctx context.Context, url URLEntry, userID string) error
 	AddMany(ctx context.Context, urls []URLEntry, userID string) error
+	AddOrGetMany(ctx context.Context, urls []URLEntry, userID string) ([]AddResult, error)
 	GetByShort(ctx context.Context, shortURL string) (*URLEntry, error)
 	GetByOriginal(ctx context.Context, origURL string) (*URLEntry, error)
-	GetURLsCreatedBy(ctx context.Context, userID string) ([]URLEntry, error)
-	MarkDeleted(ctx context.Context, urls ...EntryToDelete) error
+	GetURLsCreatedBy(ctx context.Context, userID string, filter URLFilter) ([]URLEntry, error)
+	// MarkDeleted returns the given urls that are owned by their users,
+	// including the ones deleted before.
+	MarkDeleted(ctx context.Context, urls ...EntryToDelete) ([]EntryToDelete, error)
+	RestoreDeleted(ctx context.Context, urls ...EntryToDelete) error
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) ([]string, error)
+	UpdateOriginal(ctx context.Context, shortURL string, userID string, origURL string) error
+	CountClick(ctx context.Context, shortURL string) error
+	AddClickEvents(ctx context.Context, events ...ClickEvent) error
+	GetClickStats(ctx context.Context, shortURL string, topReferrers int) (*ClickStats, error)
 
 	Ping(ctx context.Context) error
 }
@@ -22,9 +32,58 @@ type URLEntry struct {
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
 	Deleted     bool   `json:"deleted,omitempty"`
+	Alias       bool   `json:"alias,omitempty"`
+	CreatedBy   string `json:"created_by,omitempty"`
+	// Set by storage when the entry is added.
+	CreatedAt time.Time `json:"created_at"`
+
+	// Time the entry was marked deleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Entry stops working after ExpiresAt if it is set.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Entry stops working after MaxClicks clicks if it is positive.
+	MaxClicks int64 `json:"max_clicks,omitempty"`
+	Clicks    int64 `json:"clicks,omitempty"`
+}
+
+// Outcome of adding an entry in a batch.
+type AddResult struct {
+	// Short url of the added entry, or of the existing entry with the same original url.
+	ShortURL string
+	// The original url had been saved before, nothing was added for it.
+	Existed bool
+}
+
+// Represents a single redirect through a short url.
+type ClickEvent struct {
+	ShortURL  string
+	Time      time.Time
+	Referrer  string
+	UserAgent string
+	IPHash    string
 }
 
-// Entry to be marked deleted.
+// Aggregated click events of a short url.
+type ClickStats struct {
+	Total        int64
+	Daily        []DailyClicks
+	TopReferrers []ReferrerClicks
+}
+
+// Number of clicks during a day in UTC.
+type DailyClicks struct {
+	Day    time.Time
+	Clicks int64
+}
+
+// Number of clicks coming from a referrer.
+type ReferrerClicks struct {
+	Referrer string
+	Clicks   int64
+}
+
+// Entry to be marked deleted or restored.
 type EntryToDelete struct {
 	ShortURL string
 	UserID   string
@@ -33,5 +92,11 @@ type EntryToDelete struct {
 // Error when entry no unique.
 var ErrNotUnique = errors.New("not unique")
 
+// Error when short url is already taken by another entry.
+var ErrShortURLTaken = errors.New("short url is taken")
+
+// Error when entry has been clicked the maximum number of times.
+var ErrClickLimitReached = errors.New("click limit reached")
+
 // Error when entry not found.
 var ErrNotFound = errors.New("not found")
@@ -0,0 +1,195 @@
+package urlpolicy
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+)
+
+// Prefix of list lines holding regular expressions matched against the whole url.
+const patternPrefix = "re:"
+
+// Rules read from a list file.
+type rules struct {
+	domains  []string
+	patterns []*regexp.Regexp
+}
+
+func (r *rules) match(rawURL string, host string) (string, bool) {
+	for _, d := range r.domains {
+		if matchDomain(host, d) {
+			return "domain " + d, true
+		}
+	}
+
+	for _, p := range r.patterns {
+		if p.MatchString(rawURL) {
+			return "pattern " + p.String(), true
+		}
+	}
+
+	return "", false
+}
+
+// FileList checks urls against domains and patterns listed in a file.
+// In blocklist mode listed urls are rejected, in allowlist mode all other urls are.
+// The file is reloaded when it changes.
+//
+// Every non-empty line of the file is a domain, which also covers its subdomains,
+// or a regular expression prefixed with "re:". Lines starting with '#' are comments.
+type FileList struct {
+	fname     string
+	allowlist bool
+	rules     atomic.Pointer[rules]
+
+	modTime time.Time
+	size    int64
+
+	reloadPeriod time.Duration
+	log          *logger.Logger
+	stop         chan struct{}
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+// NewFileList reads the list from fname and starts checking it for changes every reloadPeriod.
+// The file is read only once if reloadPeriod is not positive.
+func NewFileList(fname string, allowlist bool, reloadPeriod time.Duration, log *logger.Logger) (*FileList, error) {
+	l := &FileList{
+		fname:        fname,
+		allowlist:    allowlist,
+		reloadPeriod: reloadPeriod,
+		log:          log,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	if _, err := l.reload(); err != nil {
+		return nil, err
+	}
+
+	go l.RunReloading()
+
+	return l, nil
+}
+
+// Close stops checking the file for changes. It is safe to call it more than once.
+func (l *FileList) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.stop)
+		<-l.done
+	})
+
+	return nil
+}
+
+// Check rejects rawURL according to the list mode.
+func (l *FileList) Check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("url cannot be parsed: %v", err)
+	}
+
+	reason, matched := l.rules.Load().match(rawURL, strings.ToLower(u.Hostname()))
+
+	switch {
+	case l.allowlist && !matched:
+		return fmt.Errorf("url is not in the allowlist")
+	case !l.allowlist && matched:
+		return fmt.Errorf("url matches blocklisted %s", reason)
+	}
+
+	return nil
+}
+
+// RunReloading reloads the list when the file changes until the list is closed.
+// If the changed file cannot be read, the previous rules are kept.
+func (l *FileList) RunReloading() {
+	defer close(l.done)
+
+	if l.reloadPeriod <= 0 {
+		<-l.stop
+		return
+	}
+
+	ticker := time.NewTicker(l.reloadPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			reloaded, err := l.reload()
+			if err != nil {
+				l.log.Errorf("failed to reload url list: %v", err)
+			} else if reloaded {
+				l.log.Infof("reloaded url list %s", l.fname)
+			}
+		}
+	}
+}
+
+// reload reads the file if it has changed since the last read.
+func (l *FileList) reload() (bool, error) {
+	info, err := os.Stat(l.fname)
+	if err != nil {
+		return false, fmt.Errorf("urlpolicy: %w", err)
+	}
+
+	if l.rules.Load() != nil && info.ModTime().Equal(l.modTime) && info.Size() == l.size {
+		return false, nil
+	}
+
+	r, err := readRules(l.fname)
+	if err != nil {
+		return false, err
+	}
+
+	l.rules.Store(r)
+	l.modTime, l.size = info.ModTime(), info.Size()
+
+	return true, nil
+}
+
+func readRules(fname string) (*rules, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("urlpolicy: %w", err)
+	}
+	defer f.Close()
+
+	var r rules
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, patternPrefix):
+			p, err := regexp.Compile(strings.TrimPrefix(line, patternPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("urlpolicy: %s:%d: %w", fname, n, err)
+			}
+			r.patterns = append(r.patterns, p)
+		default:
+			r.domains = append(r.domains, strings.ToLower(strings.Trim(line, ".")))
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("urlpolicy: %w", err)
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,71 @@
+// Package urlpolicy checks urls submitted for shortening against abuse rules.
+package urlpolicy
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Policy rejects urls which must not be shortened.
+type Policy interface {
+	// Check returns error with the reason if rawURL is not allowed.
+	Check(rawURL string) error
+}
+
+// Chain checks urls against all policies in order.
+type Chain []Policy
+
+// Check returns the first rejection of the policies.
+func (c Chain) Check(rawURL string) error {
+	for _, p := range c {
+		if err := p.Check(rawURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SelfLoop rejects urls pointing at the shortener itself, which would make redirect loops.
+type SelfLoop struct {
+	host string
+}
+
+// NewSelfLoop returns policy for the shortener with the given base url.
+func NewSelfLoop(baseURL string) SelfLoop {
+	if !strings.Contains(baseURL, "//") {
+		baseURL = "http://" + baseURL
+	}
+
+	var host string
+	if u, err := url.Parse(baseURL); err == nil {
+		host = hostname(u)
+	}
+
+	return SelfLoop{host: host}
+}
+
+// Check rejects rawURL if its host is the one of the shortener.
+// Scheme and port are ignored, the shortener may be reachable on several of them.
+func (p SelfLoop) Check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("url cannot be parsed: %v", err)
+	}
+
+	if p.host != "" && hostname(u) == p.host {
+		return fmt.Errorf("url points at the shortener itself")
+	}
+
+	return nil
+}
+
+// hostname returns lowercase host of u without the port and the trailing dot.
+func hostname(u *url.URL) string {
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+// matchDomain reports whether host is domain or its subdomain.
+func matchDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -0,0 +1,114 @@
+package urlpolicy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/KonBal/url-shortener/internal/app/logger"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSelfLoop(t *testing.T) {
+	tests := map[string]struct {
+		base    string
+		url     string
+		wantErr bool
+	}{
+		"same_host":         {base: "localhost:8080", url: "http://localhost:8080/abc", wantErr: true},
+		"same_host_case":    {base: "https://Short.ly", url: "https://short.ly/abc", wantErr: true},
+		"default_port":      {base: "https://short.ly:443", url: "https://short.ly/abc", wantErr: true},
+		"other_port":        {base: "localhost:8080", url: "http://localhost:3000/abc", wantErr: true},
+		"other_scheme":      {base: "https://short.ly", url: "http://short.ly/abc", wantErr: true},
+		"other_scheme_port": {base: "https://short.ly", url: "https://short.ly:8443/abc", wantErr: true},
+		"trailing_dot":      {base: "https://short.ly", url: "https://short.ly./abc", wantErr: true},
+		"other_host":        {base: "https://short.ly", url: "https://example.com/short.ly"},
+		"subdomain":         {base: "https://short.ly", url: "https://www.short.ly/abc"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := NewSelfLoop(tt.base).Check(tt.url)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestFileList(t *testing.T) {
+	log := logger.NewLogger(zap.NewNop())
+	fname := filepath.Join(t.TempDir(), "policy.txt")
+
+	require.NoError(t, os.WriteFile(fname, []byte(`
+# phishing
+Evil.com
+re:^https?://[^/]+/login\.php
+`), 0600))
+
+	t.Run("blocklist", func(t *testing.T) {
+		l, err := NewFileList(fname, false, time.Hour, log)
+		require.NoError(t, err)
+		defer l.Close()
+
+		require.Error(t, l.Check("http://evil.com/"))
+		require.Error(t, l.Check("https://www.evil.com/path"))
+		require.Error(t, l.Check("https://example.com/login.php"))
+		require.NoError(t, l.Check("https://notevil.com/"))
+		require.NoError(t, l.Check("https://example.com/"))
+	})
+
+	t.Run("allowlist", func(t *testing.T) {
+		l, err := NewFileList(fname, true, time.Hour, log)
+		require.NoError(t, err)
+		defer l.Close()
+
+		require.NoError(t, l.Check("http://evil.com/"))
+		require.Error(t, l.Check("https://example.com/"))
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		l, err := NewFileList(fname, false, 10*time.Millisecond, log)
+		require.NoError(t, err)
+		defer l.Close()
+
+		require.NoError(t, l.Check("https://example.com/"))
+
+		require.NoError(t, os.WriteFile(fname, []byte("example.com\n"), 0600))
+
+		require.Eventually(t, func() bool {
+			return l.Check("https://example.com/") != nil
+		}, time.Second, 10*time.Millisecond)
+
+		// Broken file keeps previous rules.
+		require.NoError(t, os.WriteFile(fname, []byte("re:(\n"), 0600))
+		time.Sleep(50 * time.Millisecond)
+		require.Error(t, l.Check("https://example.com/"))
+	})
+
+	t.Run("no_reload", func(t *testing.T) {
+		static := filepath.Join(t.TempDir(), "static.txt")
+		require.NoError(t, os.WriteFile(static, []byte("evil.com\n"), 0600))
+
+		for _, period := range []time.Duration{0, -time.Second} {
+			l, err := NewFileList(static, false, period, log)
+			require.NoError(t, err)
+			require.Error(t, l.Check("http://evil.com/"))
+
+			require.NoError(t, l.Close())
+			require.NoError(t, l.Close())
+		}
+	})
+
+	t.Run("invalid_pattern", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), "bad.txt")
+		require.NoError(t, os.WriteFile(bad, []byte("re:(\n"), 0600))
+
+		_, err := NewFileList(bad, false, time.Hour, log)
+		require.Error(t, err)
+	})
+}
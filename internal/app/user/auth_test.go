@@ -1,23 +1,27 @@
 package user
 
 import (
+	"encoding/base64"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
 type keyStoreMock struct{}
 
-func (s *keyStoreMock) Secret() []byte {
-	return []byte("secret")
+func (s *keyStoreMock) Keys() []Key {
+	return []Key{{Version: 1, Secret: []byte("secret")}}
 }
 
-func TestAuthenticate(t *testing.T) {
+func TestAuthenticateLegacy(t *testing.T) {
 	tests := map[string]struct {
 		token string
 
-		key []byte
+		key           []byte
+		legacySecrets [][]byte
+		rejectLegacy  bool
 
 		wantUser    *User
 		wantErr     bool
@@ -43,14 +47,32 @@ func TestAuthenticate(t *testing.T) {
 		"correct": {
 			token:    "7573657231323334b4879144b509c9c892ab48e0041d7e095c0e4efcc659654dd83e8fb1fe7cf88231089d7c4ed5882fef0c6b5c83673f7b",
 			key:      []byte("key"),
-			wantUser: &User{UserID: "user1234"},
+			wantUser: &User{UserID: "user1234", Stale: true},
 			wantErr:  false,
 		},
+		"legacy_secret": {
+			token:         "7573657231323334b4879144b509c9c892ab48e0041d7e095c0e4efcc659654dd83e8fb1fe7cf88231089d7c4ed5882fef0c6b5c83673f7b",
+			key:           []byte("another key"),
+			legacySecrets: [][]byte{[]byte("key")},
+			wantUser:      &User{UserID: "user1234", Stale: true},
+			wantErr:       false,
+		},
+		"legacy_rejected": {
+			token:        "7573657231323334b4879144b509c9c892ab48e0041d7e095c0e4efcc659654dd83e8fb1fe7cf88231089d7c4ed5882fef0c6b5c83673f7b",
+			key:          []byte("key"),
+			rejectLegacy: true,
+			wantErr:      true,
+			expectedErr:  ErrAuthenticationFailed.Error(),
+		},
 	}
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			auth := Authenticator{SecretKeyStore: NewKeyStore(func() []byte { return tt.key })}
+			auth := Authenticator{
+				SecretKeyStore: NewKeyStore(func() []byte { return tt.key }),
+				AcceptLegacy:   !tt.rejectLegacy,
+				LegacySecrets:  tt.legacySecrets,
+			}
 
 			got, err := auth.Authenticate(tt.token)
 			if tt.wantErr {
@@ -67,7 +89,7 @@ func TestAuthenticate(t *testing.T) {
 
 func BenchmarkAuthenticate(b *testing.B) {
 	auth := Authenticator{SecretKeyStore: NewKeyStore(func() []byte { return []byte("secret key of moderate size") })}
-	token := "7573657231323334b4879144b509c9c892ab48e0041d7e095c0e4efcc659654dd83e8fb1fe7cf88231089d7c4ed5882fef0c6b5c83673f7b"
+	token, _ := auth.Sign("user1234")
 
 	for i := 0; i < b.N; i++ {
 		auth.Authenticate(token)
@@ -79,35 +101,11 @@ func TestSign(t *testing.T) {
 		userID string
 
 		key []byte
-
-		wantToken   string
-		wantErr     bool
-		expectedErr string
 	}{
-		"empty": {
-			userID:    "",
-			wantToken: "5b2d14385bf93ea58949c4f5c533261cdf4b3b9794604f6af4203201165b86ea078189db807a1942a6c1463a94f367bf",
-			key:       []byte("key"),
-			wantErr:   false,
-		},
-		"correct": {
-			userID:    "user1234",
-			wantToken: "7573657231323334b4879144b509c9c892ab48e0041d7e095c0e4efcc659654dd83e8fb1fe7cf88231089d7c4ed5882fef0c6b5c83673f7b",
-			key:       []byte("key"),
-			wantErr:   false,
-		},
-		"nil_key": {
-			userID:    "user1234",
-			wantToken: "7573657231323334da96545f310144563c305b1cab825592e22445aed03e24e35b6340b1031510b883b35c5599bd36396f3bbff14acc0ae4",
-			key:       nil,
-			wantErr:   false,
-		},
-		"empty_key": {
-			userID:    "user1234",
-			wantToken: "7573657231323334da96545f310144563c305b1cab825592e22445aed03e24e35b6340b1031510b883b35c5599bd36396f3bbff14acc0ae4",
-			key:       []byte{},
-			wantErr:   false,
-		},
+		"empty":     {userID: "", key: []byte("key")},
+		"correct":   {userID: "user1234", key: []byte("key")},
+		"nil_key":   {userID: "user1234", key: nil},
+		"empty_key": {userID: "user1234", key: []byte{}},
 	}
 
 	for name, tt := range tests {
@@ -115,18 +113,90 @@ func TestSign(t *testing.T) {
 			auth := Authenticator{SecretKeyStore: NewKeyStore(func() []byte { return tt.key })}
 
 			got, err := auth.Sign(tt.userID)
-			if tt.wantErr {
-				require.EqualError(t, err, tt.expectedErr)
-				return
+			require.NoError(t, err)
+
+			data, err := base64.RawURLEncoding.DecodeString(got)
+			require.NoError(t, err)
+			require.Equal(t, tokenVersion, data[0])
+			if tt.userID != "" {
+				require.NotContains(t, string(data), tt.userID, "user id is encrypted")
 			}
 
+			// Every token gets its own nonce.
+			again, err := auth.Sign(tt.userID)
 			require.NoError(t, err)
+			require.NotEqual(t, got, again)
 
-			require.Equal(t, tt.wantToken, got)
+			u, err := auth.Authenticate(got)
+			require.NoError(t, err)
+			require.Equal(t, &User{UserID: tt.userID}, u)
 		})
 	}
 }
 
+func TestAuthenticate(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	auth := Authenticator{
+		SecretKeyStore: NewKeyStore(func() []byte { return []byte("key") }),
+		TTL:            time.Hour,
+		RenewBefore:    15 * time.Minute,
+		now:            func() time.Time { return now },
+	}
+
+	token, err := auth.Sign("user1234")
+	require.NoError(t, err)
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	require.NoError(t, err)
+
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 1
+
+	anotherKeyVersion := append([]byte{}, data...)
+	anotherKeyVersion[4] ^= 1
+
+	unknownVersion := append([]byte{}, data...)
+	unknownVersion[0] = tokenVersion + 1
+
+	tests := map[string]struct {
+		token string
+		now   time.Time
+
+		wantUser *User
+		wantErr  bool
+	}{
+		"correct":             {token: token, now: now.Add(30 * time.Minute), wantUser: &User{UserID: "user1234"}},
+		"near_expiry":         {token: token, now: now.Add(59 * time.Minute), wantUser: &User{UserID: "user1234", Stale: true}},
+		"expired":             {token: token, now: now.Add(time.Hour), wantErr: true},
+		"tampered":            {token: base64.RawURLEncoding.EncodeToString(tampered), now: now, wantErr: true},
+		"another_key_version": {token: base64.RawURLEncoding.EncodeToString(anotherKeyVersion), now: now, wantErr: true},
+		"unknown_version":     {token: base64.RawURLEncoding.EncodeToString(unknownVersion), now: now, wantErr: true},
+		"truncated":           {token: base64.RawURLEncoding.EncodeToString(data[:8]), now: now, wantErr: true},
+		"garbage":             {token: "not a token", now: now, wantErr: true},
+	}
+
+	// Failed tokens must not depend on whether legacy tokens are accepted.
+	for _, legacy := range []bool{false, true} {
+		for name, tt := range tests {
+			t.Run(fmt.Sprintf("%s/legacy=%v", name, legacy), func(t *testing.T) {
+				a := auth
+				a.AcceptLegacy = legacy
+				a.now = func() time.Time { return tt.now }
+
+				got, err := a.Authenticate(tt.token)
+				if tt.wantErr {
+					require.ErrorIs(t, err, ErrAuthenticationFailed)
+					return
+				}
+
+				require.NoError(t, err)
+				require.Equal(t, tt.wantUser, got)
+			})
+		}
+	}
+}
+
 func BenchmarkSign(b *testing.B) {
 	auth := Authenticator{SecretKeyStore: NewKeyStore(func() []byte { return []byte("secret key of moderate size") })}
 	token := "userID12345"
@@ -173,7 +243,13 @@ func TestSignAndAuth(t *testing.T) {
 func ExampleAuthenticator_Authenticate() {
 	auth := Authenticator{SecretKeyStore: NewKeyStore(func() []byte { return []byte("key") })}
 
-	user, err := auth.Authenticate("7573657231323334b4879144b509c9c892ab48e0041d7e095c0e4efcc659654dd83e8fb1fe7cf88231089d7c4ed5882fef0c6b5c83673f7b")
+	token, err := auth.Sign("user1234")
+	if err != nil {
+		fmt.Printf("failed to sign: %v", err)
+		return
+	}
+
+	user, err := auth.Authenticate(token)
 	if err != nil {
 		fmt.Printf("failed to authenticate: %v", err)
 		return
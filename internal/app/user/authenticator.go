@@ -3,123 +3,157 @@ package user
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/hmac"
+	crand "crypto/rand"
 	"crypto/sha256"
-	"encoding/hex"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"time"
+)
+
+// Lifetime of tokens when Authenticator.TTL is not set.
+const defaultTokenTTL = 30 * 24 * time.Hour
+
+// Version of the token format written by Sign.
+const tokenVersion byte = 1
+
+// Token layout: version byte, key version, nonce, then the sealed claims.
+// The version byte and key version are authenticated as additional data.
+const (
+	tokenHeaderSize = 1 + 4
+	claimsSize      = 8 + 8
 )
 
 // Authenticates user.
 type Authenticator struct {
-	SecretKeyStore interface{ Secret() []byte }
+	// Keys that are accepted, the first one is used for signing.
+	SecretKeyStore interface{ Keys() []Key }
+	// Lifetime of signed tokens, 30 days if zero.
+	TTL time.Duration
+	// Tokens expiring sooner than RenewBefore are reported stale to be signed again.
+	RenewBefore time.Duration
+	// Accept tokens of the format used before versioned tokens. They never expire,
+	// so they are reported stale to be replaced with tokens of the current format.
+	AcceptLegacy bool
+	// Secrets that signed legacy tokens besides the keys of the store, never used for current tokens.
+	LegacySecrets [][]byte
+
+	now func() time.Time
 }
 
 // Error when authentication is failed.
 var ErrAuthenticationFailed = errors.New("authenticaion failed")
 
 // Authenticate comfirms the identity of a user given a signed auth token.
+// Tokens signed with any accepted key pass, those signed with an older key or nearing expiry are marked stale.
 // Returns ErrAuthenticationFailed error in case of failure.
 func (s Authenticator) Authenticate(token string) (*User, error) {
-	data, err := hex.DecodeString(token)
-	if err != nil {
-		return nil, fmt.Errorf("auth: %w", err)
+	// Hex encoded legacy tokens never decode to data starting with the version byte,
+	// so tokens of the current format that fail are not checked as legacy ones.
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil && len(data) > 0 && data[0] == tokenVersion {
+		return s.authenticate(data)
 	}
 
-	secret := sha256.Sum256(s.SecretKeyStore.Secret())
-	key := secret[:]
-
-	if len(data) < signatureSize {
-		return nil, ErrAuthenticationFailed
+	if s.AcceptLegacy {
+		return s.authenticateLegacy(token)
 	}
 
-	encrSign := data[len(data)-signatureSize:]
-	id := data[0 : len(data)-signatureSize]
+	return nil, ErrAuthenticationFailed
+}
 
-	sign, err := decrypt(encrSign, key)
-	if err != nil {
+func (s Authenticator) authenticate(data []byte) (*User, error) {
+	if len(data) < tokenHeaderSize {
 		return nil, ErrAuthenticationFailed
 	}
 
-	h := hmac.New(sha256.New, key)
-	h.Write(id)
-	hash := h.Sum(nil)
+	header := data[:tokenHeaderSize]
+	keyVersion := int(binary.BigEndian.Uint32(header[1:]))
 
-	if hmac.Equal(sign, hash) {
-		return &User{UserID: string(id)}, nil
-	}
+	for i, k := range s.SecretKeyStore.Keys() {
+		if k.Version != keyVersion {
+			continue
+		}
 
-	return nil, ErrAuthenticationFailed
-}
+		aead, err := newAEAD(k.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
 
-// Sign cryptographically signs the given token.
-func (s Authenticator) Sign(token string) (string, error) {
-	data := []byte(token)
+		rest := data[tokenHeaderSize:]
+		if len(rest) < aead.NonceSize() {
+			return nil, ErrAuthenticationFailed
+		}
 
-	secret := sha256.Sum256(s.SecretKeyStore.Secret())
-	key := secret[:]
+		claims, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+		if err != nil || len(claims) < claimsSize {
+			return nil, ErrAuthenticationFailed
+		}
 
-	h := hmac.New(sha256.New, key)
-	h.Write(data)
-	hash := h.Sum(nil)
+		now := s.clock()
+		expiresAt := time.Unix(int64(binary.BigEndian.Uint64(claims[8:16])), 0)
+		if !now.Before(expiresAt) {
+			return nil, ErrAuthenticationFailed
+		}
 
-	signature, err := encrypt(hash, key)
-	if err != nil {
-		return "", fmt.Errorf("auth: failed to encrypt hash: %w", err)
-	}
+		stale := i > 0 || expiresAt.Sub(now) < s.RenewBefore
 
-	signed := append(data, signature...)
+		return &User{UserID: string(claims[claimsSize:]), Stale: stale}, nil
+	}
 
-	return hex.EncodeToString(signed), nil
+	return nil, ErrAuthenticationFailed
 }
 
-var signatureSize = sha256.Size + aes.BlockSize
+// Sign seals the given token with the newest key, a random nonce, issue and expiry times.
+func (s Authenticator) Sign(token string) (string, error) {
+	k := s.SecretKeyStore.Keys()[0]
 
-func encrypt(data, key []byte) ([]byte, error) {
-	aesgcm, nonce, err := initCipher(key)
+	aead, err := newAEAD(k.Secret)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("auth: %w", err)
 	}
 
-	return aesgcm.Seal(nil, nonce, data, nil), nil
-}
+	header := make([]byte, tokenHeaderSize)
+	header[0] = tokenVersion
+	binary.BigEndian.PutUint32(header[1:], uint32(k.Version))
 
-func decrypt(data, key []byte) ([]byte, error) {
-	aesgcm, nonce, err := initCipher(key)
-	if err != nil {
-		return nil, err
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := crand.Read(nonce); err != nil {
+		return "", fmt.Errorf("auth: failed to generate nonce: %w", err)
 	}
 
-	return aesgcm.Open(nil, nonce, data, nil)
-}
-
-func initCipher(key []byte) (cipher.AEAD, []byte, error) {
-	aesblock, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, nil, err
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
 	}
 
-	aesgcm, err := cipher.NewGCM(aesblock)
-	if err != nil {
-		return nil, nil, err
-	}
+	issuedAt := s.clock()
+	claims := make([]byte, claimsSize, claimsSize+len(token))
+	binary.BigEndian.PutUint64(claims[0:8], uint64(issuedAt.Unix()))
+	binary.BigEndian.PutUint64(claims[8:16], uint64(issuedAt.Add(ttl).Unix()))
+	claims = append(claims, token...)
 
-	nonce := key[len(key)-aesgcm.NonceSize():]
+	data := append(header, nonce...)
+	data = aead.Seal(data, nonce, claims, header)
 
-	return aesgcm, nonce, nil
+	return base64.RawURLEncoding.EncodeToString(data), nil
 }
 
-// Stores key.
-type KeyStore struct {
-	keyFunc func() []byte
+func (s Authenticator) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
 }
 
-// NewKeyStore returns new key store.
-func NewKeyStore(f func() []byte) KeyStore {
-	return KeyStore{keyFunc: f}
-}
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	key := sha256.Sum256(secret)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
 
-// Secret returns key as byte slice.
-func (s KeyStore) Secret() []byte {
-	return s.keyFunc()
+	return cipher.NewGCM(block)
 }
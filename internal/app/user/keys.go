@@ -0,0 +1,121 @@
+package user
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Secret key used to sign auth tokens.
+type Key struct {
+	Version int
+	Secret  []byte
+	// Tokens signed with the key are rejected after RetiresAt if it is set.
+	RetiresAt time.Time
+}
+
+// Stores versioned keys. Tokens are signed with the newest key
+// and accepted with any key that is not retired yet.
+type KeyStore struct {
+	keys []Key
+	now  func() time.Time
+}
+
+// NewKeyStore returns key store with a single key that never retires.
+func NewKeyStore(f func() []byte) KeyStore {
+	return KeyStore{keys: []Key{{Version: 1, Secret: f()}}, now: time.Now}
+}
+
+// NewVersionedKeyStore returns key store with the given keys, which must have distinct versions.
+func NewVersionedKeyStore(keys []Key) (KeyStore, error) {
+	if len(keys) == 0 {
+		return KeyStore{}, errors.New("keys: no keys given")
+	}
+
+	sorted := make([]Key, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version > sorted[j].Version })
+
+	for i, k := range sorted {
+		if len(k.Secret) == 0 {
+			return KeyStore{}, fmt.Errorf("keys: key %d is empty", k.Version)
+		}
+		if i > 0 && sorted[i-1].Version == k.Version {
+			return KeyStore{}, fmt.Errorf("keys: duplicate key version %d", k.Version)
+		}
+	}
+
+	return KeyStore{keys: sorted, now: time.Now}, nil
+}
+
+// Secret returns the newest key as byte slice.
+func (s KeyStore) Secret() []byte {
+	return s.keys[0].Secret
+}
+
+// Keys returns keys that are not retired, the newest first.
+// The newest key is returned even if it is retired, so that tokens can still be signed.
+func (s KeyStore) Keys() []Key {
+	now := s.now()
+
+	keys := []Key{s.keys[0]}
+	for _, k := range s.keys[1:] {
+		if k.RetiresAt.IsZero() || now.Before(k.RetiresAt) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// ReadKeyFile reads keys from file, one key per line:
+//
+//	<version> <secret> [<retirement time in RFC 3339>]
+//
+// Empty lines and lines starting with # are skipped.
+func ReadKeyFile(fname string) ([]Key, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("keys: %w", err)
+	}
+	defer f.Close()
+
+	var keys []Key
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("keys: line %d: want version, secret and optional retirement time", n)
+		}
+
+		version, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("keys: line %d: invalid version: %w", n, err)
+		}
+
+		k := Key{Version: version, Secret: []byte(fields[1])}
+		if len(fields) == 3 {
+			if k.RetiresAt, err = time.Parse(time.RFC3339, fields[2]); err != nil {
+				return nil, fmt.Errorf("keys: line %d: invalid retirement time: %w", n, err)
+			}
+		}
+
+		keys = append(keys, k)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("keys: %w", err)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,110 @@
+package user
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRotation(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	old := Authenticator{SecretKeyStore: NewKeyStore(func() []byte { return []byte("old") })}
+	token, err := old.Sign("user1234")
+	require.NoError(t, err)
+
+	keys, err := NewVersionedKeyStore([]Key{
+		{Version: 1, Secret: []byte("old"), RetiresAt: now.Add(time.Hour)},
+		{Version: 2, Secret: []byte("new")},
+	})
+	require.NoError(t, err)
+	keys.now = func() time.Time { return now }
+	auth := Authenticator{SecretKeyStore: keys}
+
+	u, err := auth.Authenticate(token)
+	require.NoError(t, err)
+	require.Equal(t, &User{UserID: "user1234", Stale: true}, u)
+
+	resigned, err := auth.Sign(u.UserID)
+	require.NoError(t, err)
+
+	u, err = auth.Authenticate(resigned)
+	require.NoError(t, err)
+	require.Equal(t, &User{UserID: "user1234"}, u)
+
+	keys.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+	for _, legacy := range []bool{false, true} {
+		auth = Authenticator{SecretKeyStore: keys, AcceptLegacy: legacy}
+
+		_, err = auth.Authenticate(token)
+		require.ErrorIs(t, err, ErrAuthenticationFailed)
+
+		_, err = auth.Authenticate(resigned)
+		require.NoError(t, err)
+	}
+}
+
+func TestNewVersionedKeyStore(t *testing.T) {
+	tests := map[string]struct {
+		keys    []Key
+		wantErr bool
+	}{
+		"no_keys":   {wantErr: true},
+		"empty_key": {keys: []Key{{Version: 1}}, wantErr: true},
+		"duplicate": {keys: []Key{{Version: 1, Secret: []byte("a")}, {Version: 1, Secret: []byte("b")}}, wantErr: true},
+		"correct":   {keys: []Key{{Version: 1, Secret: []byte("a")}, {Version: 2, Secret: []byte("b")}}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewVersionedKeyStore(tt.keys)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, []byte("b"), s.Secret())
+		})
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	tests := map[string]struct {
+		content string
+
+		want    []Key
+		wantErr bool
+	}{
+		"correct": {
+			content: "# rotated monthly\n1 old 2023-02-01T00:00:00Z\n\n2 new\n",
+			want: []Key{
+				{Version: 1, Secret: []byte("old"), RetiresAt: time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)},
+				{Version: 2, Secret: []byte("new")},
+			},
+		},
+		"no_secret":       {content: "1\n", wantErr: true},
+		"invalid_version": {content: "v1 secret\n", wantErr: true},
+		"invalid_time":    {content: "1 secret tomorrow\n", wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "keys")
+			require.NoError(t, os.WriteFile(fname, []byte(tt.content), 0600))
+
+			got, err := ReadKeyFile(fname)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
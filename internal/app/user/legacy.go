@@ -0,0 +1,75 @@
+package user
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Tokens of the legacy format are hex encoded user id followed by HMAC of it,
+// encrypted with a nonce derived from the key. They carry neither key version nor expiry.
+
+var signatureSize = sha256.Size + aes.BlockSize
+
+// authenticateLegacy checks a legacy token against every accepted key and legacy secret.
+// Users of valid tokens are always stale.
+func (s Authenticator) authenticateLegacy(token string) (*User, error) {
+	data, err := hex.DecodeString(token)
+	if err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+
+	if len(data) < signatureSize {
+		return nil, ErrAuthenticationFailed
+	}
+
+	encrSign := data[len(data)-signatureSize:]
+	id := data[0 : len(data)-signatureSize]
+
+	for _, k := range s.SecretKeyStore.Keys() {
+		if verifyLegacy(id, encrSign, k.Secret) {
+			return &User{UserID: string(id), Stale: true}, nil
+		}
+	}
+
+	for _, secret := range s.LegacySecrets {
+		if verifyLegacy(id, encrSign, secret) {
+			return &User{UserID: string(id), Stale: true}, nil
+		}
+	}
+
+	return nil, ErrAuthenticationFailed
+}
+
+func verifyLegacy(id, encrSign, secret []byte) bool {
+	hashed := sha256.Sum256(secret)
+	key := hashed[:]
+
+	sign, err := decryptLegacy(encrSign, key)
+	if err != nil {
+		return false
+	}
+
+	h := hmac.New(sha256.New, key)
+	h.Write(id)
+
+	return hmac.Equal(sign, h.Sum(nil))
+}
+
+func decryptLegacy(data, key []byte) ([]byte, error) {
+	aesblock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aesgcm, err := cipher.NewGCM(aesblock)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := key[len(key)-aesgcm.NonceSize():]
+
+	return aesgcm.Open(nil, nonce, data, nil)
+}
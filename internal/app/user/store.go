@@ -12,6 +12,8 @@ type rand interface {
 // Represents user for authentication.
 type User struct {
 	UserID string
+	// The token of the user is of old format, signed with an old key or nearing expiry, and should be signed again.
+	Stale bool
 }
 
 // Store of users.
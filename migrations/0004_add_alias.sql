@@ -0,0 +1,8 @@
+-- +goose Up
+
+alter table urls
+add column if not exists alias boolean not null default false;
+
+create unique index if not exists urls_short_url_alias_idx
+on urls (short_url)
+where alias;
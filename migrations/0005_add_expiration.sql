@@ -0,0 +1,6 @@
+-- +goose Up
+
+alter table urls
+add column if not exists expires_at timestamptz,
+add column if not exists max_clicks bigint not null default 0,
+add column if not exists clicks bigint not null default 0;
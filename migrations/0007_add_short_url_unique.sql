@@ -0,0 +1,17 @@
+-- +goose Up
+
+-- Short urls generated before this migration could collide with each other or with aliases.
+-- The oldest entry keeps the code, others are re-keyed with a suffix no code or alias can contain,
+-- so their owners still see them in their lists. Clicks cannot be told apart and stay with the kept code.
+update urls u
+set short_url = u.short_url || '~' || u.id
+from (
+	select id, row_number() over (partition by short_url order by id) as n
+	from urls
+) d
+where d.id = u.id and d.n > 1;
+
+drop index if exists urls_short_url_alias_idx;
+
+create unique index if not exists urls_short_url_idx
+on urls (short_url);
@@ -0,0 +1,3 @@
+-- +goose Up
+
+create sequence if not exists url_id_blocks;
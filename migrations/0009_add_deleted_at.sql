@@ -0,0 +1,8 @@
+-- +goose Up
+
+alter table urls
+add column if not exists deleted_at timestamptz;
+
+update urls
+set deleted_at = now()
+where deleted and deleted_at is null;
@@ -0,0 +1,5 @@
+-- +goose Up
+
+create index if not exists urls_deleted_at_idx
+on urls (deleted_at)
+where deleted;
@@ -0,0 +1,7 @@
+-- +goose Up
+
+alter table urls
+add column if not exists created_at timestamptz not null default now();
+
+create index if not exists urls_created_by_created_at_idx
+on urls (created_by, created_at, short_url);